@@ -0,0 +1,57 @@
+package config
+
+import "fmt"
+
+// CacheBudget returns how many bytes the cached prefix may hold. An explicit
+// cache_max_bytes wins. Otherwise the prefix gets an equal share of what the
+// explicit budgets leave of cache.memory_limit, or zero, meaning unlimited,
+// when there is no memory limit.
+func (c Config) CacheBudget(prefix Prefix) ByteSize {
+	if prefix.CacheMaxBytes > 0 || c.Cache.MemoryLimit == 0 {
+		return prefix.CacheMaxBytes
+	}
+
+	explicit, unsized := c.cacheBudgetUsage()
+	if unsized == 0 || explicit >= c.Cache.MemoryLimit {
+		return 0
+	}
+
+	return (c.Cache.MemoryLimit - explicit) / ByteSize(unsized)
+}
+
+// cacheBudgetUsage sums the explicit budgets and counts cached prefixes without one.
+func (c Config) cacheBudgetUsage() (ByteSize, int) {
+	explicit, unsized := ByteSize(0), 0
+	for _, prefix := range c.Prefixes {
+		if !prefix.CacheEnabled {
+			continue
+		}
+
+		if prefix.CacheMaxBytes > 0 {
+			explicit += prefix.CacheMaxBytes
+		} else {
+			unsized++
+		}
+	}
+
+	return explicit, unsized
+}
+
+func (c Config) validateCacheBudgets() error {
+	if c.Cache.MemoryLimit < 0 {
+		return fmt.Errorf("cache.memory_limit must not be negative, got %d", c.Cache.MemoryLimit)
+	}
+	if c.Cache.MemoryLimit == 0 {
+		return nil
+	}
+
+	explicit, unsized := c.cacheBudgetUsage()
+	if explicit > c.Cache.MemoryLimit {
+		return fmt.Errorf("cache budgets of %s exceed cache.memory_limit of %s", explicit, c.Cache.MemoryLimit)
+	}
+	if unsized > 0 && explicit == c.Cache.MemoryLimit {
+		return fmt.Errorf("cache.memory_limit of %s leaves nothing for %d cached prefixes without cache_max_bytes", c.Cache.MemoryLimit, unsized)
+	}
+
+	return nil
+}
@@ -1,30 +1,153 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/yaml.v3"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Prefix struct {
-	URI                  string        `yaml:"uri"`
-	RedisPrefix          string        `yaml:"redis_prefix"`
-	CacheEnabled         bool          `yaml:"cache_enabled"`
-	CacheRefreshDuration time.Duration `yaml:"cache_refresh_duration"`
-	CacheTtl             time.Duration `yaml:"cache_ttl"`
+	URI                  string              `yaml:"uri"`
+	RedisPrefix          string              `yaml:"redis_prefix"`
+	RedisKey             string              `yaml:"redis_key"`
+	CacheEnabled         bool                `yaml:"cache_enabled"`
+	CacheRefreshDuration time.Duration       `yaml:"cache_refresh_duration"`
+	CacheTtl             time.Duration       `yaml:"cache_ttl"`
+	CacheMode            string              `yaml:"cache_mode"`
+	NegativeCacheTtl     time.Duration       `yaml:"negative_cache_ttl"`
+	CacheMaxBytes        ByteSize            `yaml:"cache_max_bytes"`
+	CacheMaxItems        int64               `yaml:"cache_max_items"`
+	Indexes              []string            `yaml:"indexes"`
+	SearchFields         []string            `yaml:"search_fields"`
+	InvalidJson          string              `yaml:"invalid_json"`
+	Schema               string              `yaml:"schema"`
+	SchemaPolicy         string              `yaml:"schema_policy"`
+	CacheControl         CacheControl        `yaml:"cache_control"`
+	CompressionMinSize   ByteSize            `yaml:"compression_min_size"`
+	Relations            map[string]Relation `yaml:"relations"`
+	ReadFrom             string              `yaml:"read_from"`
+	Redis                string              `yaml:"redis"`
+	RequestTimeout       time.Duration       `yaml:"request_timeout"`
+	CoalesceQueries      bool                `yaml:"coalesce_queries"`
+	MicroCacheWindow     time.Duration       `yaml:"micro_cache_window"`
 }
 
+const (
+	CacheModeSnapshot             = "snapshot"
+	CacheModeReadThrough          = "read_through"
+	CacheModeStaleWhileRevalidate = "stale_while_revalidate"
+)
+
+// What a collection read does with stored documents that are not JSON objects.
+// Unset, filtered reads skip them and unfiltered reads return them as stored.
+const (
+	InvalidJsonSkip    = "skip"
+	InvalidJsonInclude = "include"
+	InvalidJsonFail    = "fail"
+)
+
+// What a read does with documents that do not match the prefix's schema,
+// annotate unless set. The schema is only checked on reads and by the dry run
+// POST /prefix/_validate, no write path enforces it.
+const (
+	SchemaPolicyDrop     = "drop"
+	SchemaPolicyAnnotate = "annotate"
+	SchemaPolicyPass     = "pass"
+)
+
+// Which node a prefix reads from, the primary unless set.
+const (
+	ReadFromPrimary       = "primary"
+	ReadFromReplica       = "replica"
+	ReadFromPreferReplica = "prefer_replica"
+)
+
+// Relation points from a field holding foreign ids, or an array of them, to the
+// prefix the ids belong to. It is expanded on request with ?_expand=<name>.
+type Relation struct {
+	Field  string `yaml:"field"`
+	Prefix string `yaml:"prefix"`
+}
+
+// CacheControl holds the Cache-Control directives sent to HTTP clients.
+// Durations are rounded down to whole seconds, zero leaves a directive out.
+type CacheControl struct {
+	MaxAge               time.Duration `yaml:"max_age"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	StaleIfError         time.Duration `yaml:"stale_if_error"`
+}
+
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type CircuitBreakerConfig struct {
+	Disabled         bool          `yaml:"disabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+// RedisConfig configures one Redis connection. Explicit fields override the
+// URL; DB is a pointer so that an explicit 0 does too.
 type RedisConfig struct {
-	URL           string `yaml:"url"`
-	PoolMaxIdle   int    `yaml:"pool_max_idle"`
-	PoolMaxActive int    `yaml:"pool_max_active"`
+	URL                 string               `yaml:"url"`
+	Replicas            []string             `yaml:"replicas"`
+	HealthCheckInterval time.Duration        `yaml:"health_check_interval"`
+	Username            string               `yaml:"username"`
+	Password            string               `yaml:"password"`
+	PasswordFile        string               `yaml:"password_file"`
+	DB                  *int                 `yaml:"db"`
+	TLS                 TLSConfig            `yaml:"tls"`
+	PoolMaxIdle         int                  `yaml:"pool_max_idle"`
+	PoolMaxActive       int                  `yaml:"pool_max_active"`
+	ConnectTimeout      time.Duration        `yaml:"connect_timeout"`
+	ReadTimeout         time.Duration        `yaml:"read_timeout"`
+	WriteTimeout        time.Duration        `yaml:"write_timeout"`
+	IdleTimeout         time.Duration        `yaml:"idle_timeout"`
+	MaxConnLifetime     time.Duration        `yaml:"max_conn_lifetime"`
+	Wait                bool                 `yaml:"wait"`
+	CircuitBreaker      CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+type CacheConfig struct {
+	// MemoryLimit is the budget all cached prefixes share. Zero means no limit.
+	// A refresh keeps the previous snapshot until the new one replaces it, so
+	// leave headroom for a prefix's snapshot twice over.
+	MemoryLimit ByteSize `yaml:"memory_limit"`
 }
+
+// GraphQLConfig bounds the queries /graphql accepts. InferRefresh is how long
+// the schema inferred for a prefix without one is kept, by /graphql and
+// /_openapi.json?infer=true. Zero picks the defaults.
+type GraphQLConfig struct {
+	MaxDepth      int           `yaml:"max_depth"`
+	MaxComplexity int           `yaml:"max_complexity"`
+	InferRefresh  time.Duration `yaml:"infer_refresh"`
+}
+
 type Config struct {
-	ServerPort string      `yaml:"server_port"`
-	Redis      RedisConfig `yaml:"redis"`
-	Prefixes   []Prefix    `yaml:"prefixes"`
+	ServerPort    string                 `yaml:"server_port"`
+	Redis         RedisConfig            `yaml:"redis"`
+	RedisBackends map[string]RedisConfig `yaml:"redis_backends"`
+	Cache         CacheConfig            `yaml:"cache"`
+	GraphQL       GraphQLConfig          `yaml:"graphql"`
+	Prefixes      []Prefix               `yaml:"prefixes"`
 }
 
+// DefaultRedisBackend is the name of the backend configured by the top level redis block.
+const DefaultRedisBackend = "default"
+
 func LoadConfig(path string) (Config, error) {
 	data, err := os.ReadFile(path)
 	config := Config{}
@@ -39,3 +162,369 @@ func LoadConfig(path string) (Config, error) {
 
 	return config, nil
 }
+
+// Backends returns all named Redis connections. The top level redis block is
+// registered as DefaultRedisBackend when it has a URL.
+func (c Config) Backends() map[string]RedisConfig {
+	backends := make(map[string]RedisConfig, len(c.RedisBackends)+1)
+	if c.Redis.URL != "" {
+		backends[DefaultRedisBackend] = c.Redis
+	}
+	for name, backend := range c.RedisBackends {
+		backends[name] = backend
+	}
+
+	return backends
+}
+
+// BackendName returns the backend the prefix reads from.
+func (p Prefix) BackendName() string {
+	if p.Redis == "" {
+		return DefaultRedisBackend
+	}
+
+	return p.Redis
+}
+
+// Validate checks the whole configuration and returns all problems found at once.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Redis.URL != "" {
+		errs = append(errs, c.Redis.validate("redis"))
+		if _, found := c.RedisBackends[DefaultRedisBackend]; found {
+			errs = append(errs, fmt.Errorf("redis_backends.%s clashes with the top level redis block", DefaultRedisBackend))
+		}
+	}
+	for name, backend := range c.RedisBackends {
+		errs = append(errs, backend.validate("redis_backends."+name))
+	}
+
+	backends := c.Backends()
+	if len(backends) == 0 {
+		errs = append(errs, errors.New("no redis connection configured"))
+	}
+
+	// prefixes with path parameters cannot be the target of a relation, a foreign id alone does not address them
+	uris := make(map[string]bool, len(c.Prefixes))
+	for _, prefix := range c.Prefixes {
+		uris[prefix.URI] = len(prefix.PathParams()) == 0
+	}
+	for _, prefix := range c.Prefixes {
+		errs = append(errs, prefix.validate(backends))
+		errs = append(errs, prefix.validateRelations(uris))
+	}
+
+	errs = append(errs, c.validateCacheBudgets())
+
+	if c.GraphQL.MaxDepth < 0 {
+		errs = append(errs, fmt.Errorf("graphql.max_depth must not be negative, got %d", c.GraphQL.MaxDepth))
+	}
+	if c.GraphQL.MaxComplexity < 0 {
+		errs = append(errs, fmt.Errorf("graphql.max_complexity must not be negative, got %d", c.GraphQL.MaxComplexity))
+	}
+	if c.GraphQL.InferRefresh < 0 {
+		errs = append(errs, fmt.Errorf("graphql.infer_refresh must not be negative, got %s", c.GraphQL.InferRefresh))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p Prefix) validate(backends map[string]RedisConfig) error {
+	var errs []error
+	redisConfig, found := backends[p.BackendName()]
+	if !found {
+		errs = append(errs, fmt.Errorf("prefix %s: unknown redis backend %q", p.URI, p.BackendName()))
+	}
+
+	errs = append(errs, p.validateRedisKey())
+
+	switch p.CacheMode {
+	case "", CacheModeSnapshot:
+	case CacheModeReadThrough, CacheModeStaleWhileRevalidate:
+		if !p.CacheEnabled {
+			errs = append(errs, fmt.Errorf("prefix %s: cache_mode %s requires cache_enabled", p.URI, p.CacheMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("prefix %s: unknown cache_mode %q", p.URI, p.CacheMode))
+	}
+
+	if len(p.Indexes) > 0 && !p.CacheEnabled {
+		errs = append(errs, fmt.Errorf("prefix %s: indexes require cache_enabled", p.URI))
+	}
+	for _, field := range p.Indexes {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			errs = append(errs, fmt.Errorf("prefix %s: invalid index field %q", p.URI, field))
+		} else if strings.Contains(field, "[all]") || strings.Contains(field, "[none]") {
+			// an index lists documents by any value they hold, it cannot answer all or none
+			errs = append(errs, fmt.Errorf("prefix %s: index field %q must not use [all] or [none]", p.URI, field))
+		}
+	}
+
+	for _, spec := range p.SearchFields {
+		field, weight, weighted := strings.Cut(spec, "^")
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			errs = append(errs, fmt.Errorf("prefix %s: invalid search field %q", p.URI, spec))
+		} else if parsed, err := strconv.ParseFloat(weight, 64); weighted && (err != nil || parsed <= 0) {
+			errs = append(errs, fmt.Errorf("prefix %s: search field %q needs a positive weight", p.URI, spec))
+		}
+	}
+
+	switch p.InvalidJson {
+	case "", InvalidJsonSkip, InvalidJsonInclude, InvalidJsonFail:
+	default:
+		errs = append(errs, fmt.Errorf("prefix %s: unknown invalid_json %q", p.URI, p.InvalidJson))
+	}
+
+	if _, err := p.CompileSchema(); err != nil {
+		errs = append(errs, fmt.Errorf("prefix %s: schema: %w", p.URI, err))
+	}
+	switch p.SchemaPolicy {
+	case "":
+	case SchemaPolicyDrop, SchemaPolicyAnnotate, SchemaPolicyPass:
+		if p.Schema == "" {
+			errs = append(errs, fmt.Errorf("prefix %s: schema_policy %s requires a schema", p.URI, p.SchemaPolicy))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("prefix %s: unknown schema_policy %q", p.URI, p.SchemaPolicy))
+	}
+
+	if p.CacheMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: cache_max_bytes must not be negative, got %d", p.URI, p.CacheMaxBytes))
+	}
+	if p.CacheMaxItems < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: cache_max_items must not be negative, got %d", p.URI, p.CacheMaxItems))
+	}
+
+	if p.NegativeCacheTtl < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: negative_cache_ttl must not be negative, got %s", p.URI, p.NegativeCacheTtl))
+	}
+
+	if p.MicroCacheWindow < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: micro_cache_window must not be negative, got %s", p.URI, p.MicroCacheWindow))
+	}
+
+	if p.CacheControl.MaxAge < 0 || p.CacheControl.StaleWhileRevalidate < 0 || p.CacheControl.StaleIfError < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: cache_control durations must not be negative", p.URI))
+	}
+
+	if p.CompressionMinSize < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: compression_min_size must not be negative, got %d", p.URI, p.CompressionMinSize))
+	}
+
+	if p.RequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("prefix %s: request_timeout must not be negative, got %s", p.URI, p.RequestTimeout))
+	}
+
+	switch p.ReadFrom {
+	case "", ReadFromPrimary, ReadFromPreferReplica:
+	case ReadFromReplica:
+		if found && len(redisConfig.Replicas) == 0 {
+			errs = append(errs, fmt.Errorf("prefix %s: read_from replica requires replicas on backend %q", p.URI, p.BackendName()))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("prefix %s: unknown read_from %q", p.URI, p.ReadFrom))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PathParams returns the names of the :param segments of the URI.
+func (p Prefix) PathParams() []string {
+	var params []string
+	for _, segment := range strings.Split(p.URI, "/") {
+		if name, found := strings.CutPrefix(segment, ":"); found {
+			params = append(params, name)
+		}
+	}
+
+	return params
+}
+
+var keyPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validateRedisKey checks that the placeholders of redis_key and the path
+// parameters of the URI match up. The template itself is parsed by the service.
+func (p Prefix) validateRedisKey() error {
+	params := p.PathParams()
+	if p.RedisKey == "" {
+		if len(params) > 0 {
+			return fmt.Errorf("prefix %s: path parameters require redis_key", p.URI)
+		}
+		return nil
+	}
+	if p.RedisPrefix != "" {
+		return fmt.Errorf("prefix %s: redis_prefix and redis_key are mutually exclusive", p.URI)
+	}
+
+	placeholders := make(map[string]bool)
+	ids := 0
+	for _, match := range keyPlaceholder.FindAllStringSubmatch(p.RedisKey, -1) {
+		if match[1] == "id" {
+			ids++
+			continue
+		}
+		placeholders[match[1]] = true
+	}
+	if ids != 1 {
+		return fmt.Errorf("prefix %s: redis_key must contain {id} exactly once", p.URI)
+	}
+
+	for _, param := range params {
+		if param == "id" {
+			return fmt.Errorf("prefix %s: path parameter :id is reserved for the document id", p.URI)
+		}
+		if !placeholders[param] {
+			return fmt.Errorf("prefix %s: path parameter :%s is not used in redis_key", p.URI, param)
+		}
+		delete(placeholders, param)
+	}
+	for placeholder := range placeholders {
+		return fmt.Errorf("prefix %s: redis_key placeholder {%s} has no path parameter", p.URI, placeholder)
+	}
+
+	if len(params) > 0 && p.CacheEnabled {
+		return fmt.Errorf("prefix %s: cache_enabled is not supported with path parameters", p.URI)
+	}
+
+	return nil
+}
+
+func (p Prefix) validateRelations(uris map[string]bool) error {
+	var errs []error
+	for name, relation := range p.Relations {
+		if name == "" || strings.ContainsAny(name, ".,") {
+			errs = append(errs, fmt.Errorf("prefix %s: invalid relation name %q", p.URI, name))
+		}
+		if relation.Field == "" {
+			errs = append(errs, fmt.Errorf("prefix %s: relation %s needs a field", p.URI, name))
+		}
+		addressable, found := uris[relation.Prefix]
+		if !found {
+			errs = append(errs, fmt.Errorf("prefix %s: relation %s points at unknown prefix %q", p.URI, name, relation.Prefix))
+		} else if !addressable {
+			errs = append(errs, fmt.Errorf("prefix %s: relation %s points at %s, which has path parameters", p.URI, name, relation.Prefix))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r RedisConfig) Validate() error {
+	return r.validate("redis")
+}
+
+// validate reports problems with option names qualified by name, e.g. redis.read_timeout.
+func (r RedisConfig) validate(name string) error {
+	errs := []error{validateURL(name+".url", r.URL)}
+	for i, replica := range r.Replicas {
+		errs = append(errs, validateURL(fmt.Sprintf("%s.replicas[%d]", name, i), replica))
+	}
+
+	if r.Password != "" && r.PasswordFile != "" {
+		errs = append(errs, errors.New(name+": password and password_file are mutually exclusive"))
+	}
+	if r.Username != "" && r.Password == "" && r.PasswordFile == "" {
+		errs = append(errs, errors.New(name+".username requires password or password_file"))
+	}
+	errs = append(errs, checkReadable(name+".password_file", r.PasswordFile))
+
+	if r.DB != nil && *r.DB < 0 {
+		errs = append(errs, fmt.Errorf("%s.db must not be negative, got %d", name, *r.DB))
+	}
+	if r.PoolMaxIdle < 0 {
+		errs = append(errs, fmt.Errorf("%s.pool_max_idle must not be negative, got %d", name, r.PoolMaxIdle))
+	}
+	if r.PoolMaxActive < 0 {
+		errs = append(errs, fmt.Errorf("%s.pool_max_active must not be negative, got %d", name, r.PoolMaxActive))
+	}
+	if r.PoolMaxActive > 0 && r.PoolMaxIdle > r.PoolMaxActive {
+		errs = append(errs, fmt.Errorf("%s.pool_max_idle (%d) must not exceed pool_max_active (%d)", name, r.PoolMaxIdle, r.PoolMaxActive))
+	}
+	if r.CircuitBreaker.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s.circuit_breaker.failure_threshold must not be negative, got %d", name, r.CircuitBreaker.FailureThreshold))
+	}
+	if r.Wait && r.PoolMaxActive == 0 {
+		errs = append(errs, errors.New(name+".wait requires pool_max_active to be set"))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"connect_timeout", r.ConnectTimeout},
+		{"read_timeout", r.ReadTimeout},
+		{"write_timeout", r.WriteTimeout},
+		{"idle_timeout", r.IdleTimeout},
+		{"max_conn_lifetime", r.MaxConnLifetime},
+		{"health_check_interval", r.HealthCheckInterval},
+		{"circuit_breaker.open_timeout", r.CircuitBreaker.OpenTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s must not be negative, got %s", name, d.name, d.value))
+		}
+	}
+
+	errs = append(errs, r.TLS.validate(name+".tls", r.UseTLS()))
+
+	return errors.Join(errs...)
+}
+
+// UseTLS reports whether connections are encrypted, either explicitly or via the rediss:// scheme.
+func (r RedisConfig) UseTLS() bool {
+	if r.TLS.Enabled {
+		return true
+	}
+
+	u, err := url.Parse(r.URL)
+	return err == nil && u.Scheme == "rediss"
+}
+
+func validateURL(name string, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return fmt.Errorf("%s: unsupported scheme %q, expected redis or rediss", name, u.Scheme)
+	}
+
+	return nil
+}
+
+func (t TLSConfig) validate(name string, enabled bool) error {
+	if !enabled {
+		if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" || t.InsecureSkipVerify {
+			return errors.New(name + " options are set but TLS is not enabled")
+		}
+		return nil
+	}
+
+	var errs []error
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, errors.New(name+".cert_file and "+name+".key_file must be set together"))
+	}
+	errs = append(errs,
+		checkReadable(name+".ca_file", t.CAFile),
+		checkReadable(name+".cert_file", t.CertFile),
+		checkReadable(name+".key_file", t.KeyFile),
+	)
+
+	return errors.Join(errs...)
+}
+
+func checkReadable(name string, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	_ = file.Close()
+
+	return nil
+}
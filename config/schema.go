@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+
+	"redis-go-dispatcher/schema"
+)
+
+// LoadSchema reads the JSON Schema file describing the documents of the prefix,
+// nil when none is configured.
+func (p Prefix) LoadSchema() (map[string]interface{}, error) {
+	if p.Schema == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(p.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// CompileSchema loads the schema of the prefix ready for validation, nil when none is configured.
+func (p Prefix) CompileSchema() (*schema.Schema, error) {
+	loaded, err := p.LoadSchema()
+	if err != nil || loaded == nil {
+		return nil, err
+	}
+
+	return schema.Compile(loaded)
+}
@@ -3,11 +3,18 @@ module redis-go-dispatcher
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/dgraph-io/ristretto v0.1.1
 	github.com/gomodule/redigo v1.8.9
+	github.com/graphql-go/graphql v0.8.1
+	github.com/klauspost/compress v1.16.0
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/phayes/freeport v0.0.0-20220201140144-74d24b5ae9f5
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	github.com/swaggo/files/v2 v2.0.2
 	github.com/testcontainers/testcontainers-go/modules/redis v0.23.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/text v0.11.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -24,7 +31,6 @@ require (
 	github.com/golang/glog v1.1.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/google/uuid v1.3.0 // indirect
-	github.com/klauspost/compress v1.16.0 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -42,7 +48,6 @@ require (
 	golang.org/x/exp v0.0.0-20230510235704-dd950f8aeaea // indirect
 	golang.org/x/mod v0.9.0 // indirect
 	golang.org/x/net v0.12.0 // indirect
-	golang.org/x/text v0.11.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230525234030-28d5490b6b19 // indirect
 	google.golang.org/grpc v1.57.0 // indirect
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels are rendered in key order, so the same set always yields the same series.
+type Labels map[string]string
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type Registry struct {
+	mutex    sync.RWMutex
+	counters map[string]*Counter
+	gauges   map[string]func() float64
+	// types holds the Prometheus type of every metric family, by name
+	types map[string]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]func() float64),
+		types:    make(map[string]string),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// NewCounter returns the counter for name and labels from the default registry,
+// creating it on first use.
+func NewCounter(name string, labels Labels) *Counter {
+	return defaultRegistry.Counter(name, labels)
+}
+
+// NewGaugeFunc registers a gauge in the default registry that is evaluated on every scrape.
+func NewGaugeFunc(name string, labels Labels, f func() float64) {
+	defaultRegistry.GaugeFunc(name, labels, f)
+}
+
+// WriteText writes the default registry in the Prometheus text format.
+func WriteText(w io.Writer) error {
+	return defaultRegistry.WriteText(w)
+}
+
+func (r *Registry) Counter(name string, labels Labels) *Counter {
+	series := seriesName(name, labels)
+
+	r.mutex.RLock()
+	counter, found := r.counters[series]
+	r.mutex.RUnlock()
+	if found {
+		return counter
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if counter, found = r.counters[series]; !found {
+		counter = &Counter{}
+		r.counters[series] = counter
+		r.types[name] = "counter"
+	}
+
+	return counter
+}
+
+func (r *Registry) GaugeFunc(name string, labels Labels, f func() float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.gauges[seriesName(name, labels)] = f
+	r.types[name] = "gauge"
+}
+
+// WriteText writes every family under its # TYPE line, families and series sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.RLock()
+	families := make(map[string][]string, len(r.types))
+	for series, counter := range r.counters {
+		name := familyName(series)
+		families[name] = append(families[name], fmt.Sprintf("%s %d", series, counter.Value()))
+	}
+	gauges := make(map[string]func() float64, len(r.gauges))
+	for series, f := range r.gauges {
+		gauges[series] = f
+	}
+	types := make(map[string]string, len(r.types))
+	for name, metricType := range r.types {
+		types[name] = metricType
+	}
+	r.mutex.RUnlock()
+
+	// gauges are evaluated outside the lock, they may be slow or take locks themselves
+	for series, f := range gauges {
+		name := familyName(series)
+		families[name] = append(families[name], fmt.Sprintf("%s %g", series, f()))
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lines := families[name]
+		sort.Strings(lines)
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, types[name]); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func familyName(series string) string {
+	name, _, _ := strings.Cut(series, "{")
+	return name
+}
+
+func seriesName(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[key])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, value))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,92 @@
+// Package schema validates decoded JSON documents against a JSON Schema. It
+// wraps github.com/santhosh-tekuri/jsonschema and reports violations as flat
+// path and message pairs. Schemas without $schema are read as draft 2020-12,
+// $ref may only point inside the schema itself.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Error is one violation. Path is a JSON pointer to the offending value, "" for the document.
+type Error struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return e.Path + ": " + e.Message
+}
+
+type Schema struct {
+	compiled *jsonschema.Schema
+}
+
+// resourceURL names the schema while it is compiled, relative refs resolve against it.
+const resourceURL = "file:///schema.json"
+
+// Compile checks the schema can be evaluated, that patterns compile and refs resolve.
+func Compile(root map[string]interface{}) (*Schema, error) {
+	data, err := json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("cannot load %s, refs must point inside the schema", url)
+	}
+	if err := compiler.AddResource(resourceURL, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	compiled, err := compiler.Compile(resourceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{compiled: compiled}, nil
+}
+
+// Validate returns the violations of value, ordered by path. Nil means valid.
+func (s *Schema) Validate(value interface{}) []Error {
+	err := s.compiled.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	var validationError *jsonschema.ValidationError
+	if !errors.As(err, &validationError) {
+		return []Error{{Message: err.Error()}}
+	}
+
+	violations := leaves(validationError, nil)
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations
+}
+
+// leaves collects the violations that caused e, its causes without causes of their own.
+func leaves(e *jsonschema.ValidationError, result []Error) []Error {
+	if len(e.Causes) == 0 {
+		return append(result, Error{Path: e.InstanceLocation, Message: e.Message})
+	}
+
+	for _, cause := range e.Causes {
+		result = leaves(cause, result)
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"redis-go-dispatcher/metrics"
+	"redis-go-dispatcher/service"
+)
+
+func BuildAdminRouting(e *echo.Echo) {
+	e.GET("/_admin/health", handleHealth)
+	e.GET("/_admin/metrics", handleMetrics)
+}
+
+// handleHealth answers 503 as soon as one backend primary is down, so that a
+// load balancer stops sending traffic to a dispatcher that cannot serve some prefixes.
+func handleHealth(c echo.Context) error {
+	names := make([]string, 0, len(redisBackends))
+	for name := range redisBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := http.StatusOK
+	backends := make([]service.BackendStatus, 0, len(names))
+	for _, name := range names {
+		backendStatus := redisBackends[name].Status()
+		if !backendStatus.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		backends = append(backends, backendStatus)
+	}
+
+	return c.JSON(status, map[string]interface{}{"backends": backends})
+}
+
+func handleMetrics(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4")
+	c.Response().WriteHeader(http.StatusOK)
+	return metrics.WriteText(c.Response())
+}
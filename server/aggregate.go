@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"redis-go-dispatcher/service"
+	"time"
+)
+
+// handleAggregate serves GET /prefix/_aggregate. The other query parameters
+// filter the documents first, cached prefixes are aggregated on their snapshot.
+func (r *prefixRoute) handleAggregate(c echo.Context) error {
+	queryParams, err := filterParams(c, service.ParamFilter, service.ParamSearch, service.ParamGroupBy, service.ParamMetrics)
+	if err != nil {
+		return err
+	}
+	redisService, _, err := r.serviceFor(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
+	docs, err := documents(ctx, redisService)
+	if err != nil {
+		return err
+	}
+	aggregation, err := r.queryService.Aggregate(ctx, queryParams, docs)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(aggregation)
+	if err != nil {
+		return err
+	}
+
+	return r.writeComputed(c, meta, body)
+}
+
+// writeComputed sends a body derived from the documents, validated by its content.
+func (r *prefixRoute) writeComputed(c echo.Context, meta *service.ResponseMeta, body []byte) error {
+	writeMetaHeaders(c, meta)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	encoding := r.contentEncoding(c, len(body))
+	if r.writeValidators(c, withEncoding(bodyETag(body), encoding), time.Time{}) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return r.writeJSON(c, body, encoding, nil)
+}
+
+// handleFacets serves GET /prefix/_facets, the value counts of _fields among
+// the documents passing the remaining query parameters.
+func (r *prefixRoute) handleFacets(c echo.Context) error {
+	queryParams, err := filterParams(c, service.ParamFilter, service.ParamSearch, service.ParamFields, service.ParamLimit)
+	if err != nil {
+		return err
+	}
+	redisService, _, err := r.serviceFor(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
+	docs, err := documents(ctx, redisService)
+	if err != nil {
+		return err
+	}
+	facets, err := r.queryService.Facets(ctx, queryParams, docs)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(facets)
+	if err != nil {
+		return err
+	}
+
+	return r.writeComputed(c, meta, body)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"redis-go-dispatcher/service"
+	"strings"
+	"time"
+)
+
+// paramIds selects documents by id on the collection route, e.g. ?_ids=1,2,3.
+const paramIds = "_ids"
+
+// maxBatchIds bounds a single batch lookup, larger ones are rejected with 400.
+const maxBatchIds = 1000
+
+// maxBatchBodyBytes bounds the body of a batch request, larger ones are rejected with 413.
+const maxBatchBodyBytes = 1 << 20
+
+// handleGetIds serves GET /prefix?_ids=1,2,3. It is the cacheable form of handleBatch.
+func (r *prefixRoute) handleGetIds(c echo.Context, redisService RedisService, queryParams map[string][]string, expansions []*expansion) error {
+	if len(queryParams) > 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, paramIds+" cannot be combined with other query parameters")
+	}
+
+	var ids []string
+	for _, value := range queryParams[paramIds] {
+		for _, id := range strings.Split(value, ",") {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+	}
+
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
+	body, err := r.batch(ctx, redisService, ids, expansions)
+	if err != nil {
+		return err
+	}
+
+	writeMetaHeaders(c, meta)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	encoding := r.contentEncoding(c, len(body))
+	if r.writeValidators(c, withEncoding(bodyETag(body), encoding), time.Time{}) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return r.writeJSON(c, body, encoding, nil)
+}
+
+// handleBatch serves POST /prefix/_batch with a JSON array of ids as the body.
+func (r *prefixRoute) handleBatch(c echo.Context) error {
+	ids, err := readBatchIds(http.MaxBytesReader(c.Response(), c.Request().Body, maxBatchBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("the body must not exceed %d bytes", maxBatchBodyBytes))
+		}
+		return err
+	}
+
+	expansions, err := r.parseExpansion(url.Values{paramExpand: c.QueryParams()[paramExpand]})
+	if err != nil {
+		return err
+	}
+	redisService, _, err := r.serviceFor(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
+	body, err := r.batch(ctx, redisService, ids, expansions)
+	if err != nil {
+		return err
+	}
+
+	writeMetaHeaders(c, meta)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	return r.writeJSON(c, body, r.contentEncoding(c, len(body)), nil)
+}
+
+// readBatchIds decodes a JSON array of ids, stopping as soon as it holds more than maxBatchIds.
+func readBatchIds(body io.Reader) ([]string, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, batchSyntaxError(err)
+	}
+
+	ids := make([]string, 0)
+	for decoder.More() {
+		if len(ids) == maxBatchIds {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("at most %d ids per request", maxBatchIds))
+		}
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, batchSyntaxError(err)
+		}
+		switch id := value.(type) {
+		case string:
+			ids = append(ids, id)
+		case json.Number:
+			ids = append(ids, id.String())
+		default:
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ids must be strings or numbers, got %v", value))
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, batchSyntaxError(err)
+	}
+
+	return ids, nil
+}
+
+// batchSyntaxError passes an oversized body on and turns anything else into a 400.
+func batchSyntaxError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	if err == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "expected a JSON array of ids")
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, "expected a JSON array of ids: "+err.Error())
+}
+
+// batch loads ids in one go and renders them as a JSON array in request order,
+// with null in place of every id that does not exist.
+func (r *prefixRoute) batch(ctx context.Context, redisService RedisService, ids []string, expansions []*expansion) ([]byte, error) {
+	if len(ids) > maxBatchIds {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("at most %d ids per request, got %d", maxBatchIds, len(ids)))
+	}
+	for _, id := range ids {
+		if id == "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "ids must not be empty")
+		}
+	}
+
+	docs, err := redisService.GetByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		if doc != "" {
+			var keep bool
+			if docs[i], keep = r.queryService.Check(doc); !keep {
+				docs[i] = ""
+			}
+		}
+	}
+	if expansions != nil {
+		if docs, err = r.expand(ctx, docs, expansions, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	result := bytes.Buffer{}
+	result.WriteString("[")
+	for i, doc := range docs {
+		if i > 0 {
+			result.WriteString(",")
+		}
+		if doc == "" {
+			doc = "null"
+		}
+		result.WriteString(doc)
+	}
+	result.WriteString("]")
+
+	return result.Bytes(), nil
+}
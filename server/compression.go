@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	encodingZstd   = "zstd"
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// supportedEncodings in order of preference when a client rates several the same.
+var supportedEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// defaultCompressionMinSize applies when a prefix sets no compression_min_size.
+// Smaller bodies are not worth the CPU and the framing overhead.
+const defaultCompressionMinSize = 1 << 10
+
+// zstdEncoder is safe for concurrent EncodeAll calls.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// negotiateEncoding picks the content coding for an Accept-Encoding header, ""
+// meaning identity.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range supportedEncodings {
+		weight, found := weights[encoding]
+		if !found {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+
+	return best
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	if encoding == encodingZstd {
+		return zstdEncoder.EncodeAll(body, make([]byte, 0, len(body)/4)), nil
+	}
+
+	var buffer bytes.Buffer
+	var writer interface {
+		Write(p []byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case encodingBrotli:
+		writer = brotli.NewWriterLevel(&buffer, brotli.DefaultCompression)
+	default:
+		writer = gzip.NewWriter(&buffer)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// withEncoding gives each content coding its own strong validator, as the bytes on the wire differ.
+func withEncoding(etag string, encoding string) string {
+	if encoding == "" {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// contentEncoding decides how a body of size bytes is sent: the coding negotiated
+// from Accept-Encoding, or "" for identity when the body is below the prefix's
+// threshold. The ETag and the body both follow this one decision.
+func (r *prefixRoute) contentEncoding(c echo.Context, size int) string {
+	if size < r.compressionMinSize {
+		return ""
+	}
+
+	return negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding))
+}
+
+// writeJSON sends body compressed with encoding, as decided by contentEncoding.
+// encoded may supply the compressed form, e.g. from a payloadCache.
+func (r *prefixRoute) writeJSON(c echo.Context, body []byte, encoding string, encoded func() ([]byte, error)) error {
+	if encoding == "" {
+		return c.JSONBlob(http.StatusOK, body)
+	}
+
+	if encoded == nil {
+		encoded = func() ([]byte, error) { return compress(encoding, body) }
+	}
+	compressed, err := encoded()
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentEncoding, encoding)
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, compressed)
+}
+
+// payloadCache keeps the unfiltered collection body of a cached prefix, and its
+// compressed forms, for the snapshot content last served. Hot unfiltered requests
+// then neither join nor compress documents.
+type payloadCache struct {
+	current atomic.Pointer[payload]
+}
+
+type payload struct {
+	digest  uint64
+	body    []byte
+	mutex   sync.Mutex
+	encoded map[string][]byte
+}
+
+// get returns the payload of the snapshot with digest, assembling it from docs on the first request.
+func (p *payloadCache) get(digest uint64, docs []string) *payload {
+	if current := p.current.Load(); current != nil && current.digest == digest {
+		return current
+	}
+
+	fresh := &payload{digest: digest, body: joinJSON(docs), encoded: make(map[string][]byte)}
+	p.current.Store(fresh)
+	return fresh
+}
+
+// encode compresses the body once per encoding and content.
+func (p *payload) encode(encoding string) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if compressed, found := p.encoded[encoding]; found {
+		return compressed, nil
+	}
+
+	compressed, err := compress(encoding, p.body)
+	if err != nil {
+		return nil, err
+	}
+
+	p.encoded[encoding] = compressed
+	return compressed, nil
+}
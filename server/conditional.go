@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"hash/fnv"
+	"net/http"
+	conf "redis-go-dispatcher/config"
+	"strings"
+	"time"
+)
+
+// cacheControlHeader renders the configured directives, "" when none are set.
+func cacheControlHeader(cacheControl conf.CacheControl) string {
+	directives := make([]string, 0, 3)
+	add := func(name string, value time.Duration) {
+		if value > 0 {
+			directives = append(directives, fmt.Sprintf("%s=%d", name, int64(value/time.Second)))
+		}
+	}
+	add("max-age", cacheControl.MaxAge)
+	add("stale-while-revalidate", cacheControl.StaleWhileRevalidate)
+	add("stale-if-error", cacheControl.StaleIfError)
+
+	return strings.Join(directives, ", ")
+}
+
+// bodyETag is a strong validator computed from the response body itself.
+func bodyETag(body []byte) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write(body)
+	return formatETag(hash.Sum64())
+}
+
+// snapshotETag derives the validator of a collection response from the digest of
+// the cache snapshot it was read from and the normalized query, without looking at the body.
+func snapshotETag(digest uint64, normalizedQuery string) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write(binary.BigEndian.AppendUint64(nil, digest))
+	_, _ = hash.Write([]byte(normalizedQuery))
+	return formatETag(hash.Sum64())
+}
+
+func formatETag(value uint64) string {
+	return fmt.Sprintf(`"%016x"`, value)
+}
+
+// writeValidators sets ETag, Last-Modified and Cache-Control and reports whether
+// the client's copy is still current, in which case 304 is the whole answer.
+func (r *prefixRoute) writeValidators(c echo.Context, etag string, lastModified time.Time) bool {
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if r.cacheControl != "" {
+		header.Set("Cache-Control", r.cacheControl)
+	}
+
+	return notModified(c.Request(), etag, lastModified)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, as RFC 9110 asks.
+func notModified(request *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// If-None-Match uses the weak comparison
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
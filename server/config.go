@@ -1,31 +1,36 @@
 package server
 
 import (
-	"github.com/gomodule/redigo/redis"
 	"github.com/labstack/echo/v4"
 	conf "redis-go-dispatcher/config"
+	"redis-go-dispatcher/service"
 )
 
 var (
-	redisPool *redis.Pool
-	config    conf.Config
+	redisBackends map[string]*service.RedisBackend
+	config        conf.Config
 )
 
 func StartServer(loadedConfig conf.Config) {
 	config = loadedConfig
 
-	redisPool = &redis.Pool{
-		MaxIdle:   config.Redis.PoolMaxIdle,
-		MaxActive: config.Redis.PoolMaxActive,
-		Dial: func() (redis.Conn, error) {
-			return redis.DialURL(config.Redis.URL)
-		},
+	e := echo.New()
+
+	if err := config.Validate(); err != nil {
+		e.Logger.Fatal("invalid config: ", err)
 	}
 
-	e := echo.New()
+	backends, err := newRedisBackends(config)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	redisBackends = backends
 
-	BuildRouting(e)
+	BuildAdminRouting(e)
+	routes := BuildRouting(e)
+	BuildGraphQLRouting(e, routes)
+	BuildDocsRouting(e, routes)
 
-	err := e.Start(":" + config.ServerPort)
+	err = e.Start(":" + config.ServerPort)
 	e.Logger.Fatal(err)
 }
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// paramExpand names the relations to inline, e.g. ?_expand=owner,owner.company.
+const paramExpand = "_expand"
+
+// maxExpandDepth bounds nested expansion, owner.company.country is three levels.
+const maxExpandDepth = 3
+
+// relation is a resolved conf.Relation.
+type relation struct {
+	field  []string
+	target *prefixRoute
+}
+
+// expansion is one requested relation and the relations to expand below it.
+type expansion struct {
+	name     string
+	children []*expansion
+}
+
+// expanded is a document being expanded. lineage holds the documents it is
+// embedded in, itself included, so a document is never embedded below itself.
+type expanded struct {
+	doc     map[string]interface{}
+	lineage []string
+}
+
+// resolveRelations links the relations of every route to their target routes.
+func resolveRelations(routes map[string]*prefixRoute) {
+	for _, route := range routes {
+		route.relations = make(map[string]relation, len(route.prefix.Relations))
+		for name, rel := range route.prefix.Relations {
+			route.relations[name] = relation{field: strings.Split(rel.Field, "."), target: routes[rel.Prefix]}
+		}
+	}
+}
+
+// parseExpansion takes _expand out of queryParams, which must be a copy, and
+// checks every requested relation exists. It returns nil when nothing is to be expanded.
+func (r *prefixRoute) parseExpansion(queryParams map[string][]string) ([]*expansion, error) {
+	values, found := queryParams[paramExpand]
+	if !found {
+		return nil, nil
+	}
+	delete(queryParams, paramExpand)
+
+	var root []*expansion
+	for _, value := range values {
+		for _, dotted := range strings.Split(value, ",") {
+			names := strings.Split(strings.TrimSpace(dotted), ".")
+			if len(names) > maxExpandDepth {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s %s is deeper than %d levels", paramExpand, dotted, maxExpandDepth))
+			}
+
+			level, route := &root, r
+			for _, name := range names {
+				rel, found := route.relations[name]
+				if !found {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown relation %q on %s", name, route.prefix.URI))
+				}
+
+				level = &addExpansion(level, name).children
+				route = rel.target
+			}
+		}
+	}
+
+	return root, nil
+}
+
+func addExpansion(level *[]*expansion, name string) *expansion {
+	for _, existing := range *level {
+		if existing.name == name {
+			return existing
+		}
+	}
+
+	added := &expansion{name: name}
+	*level = append(*level, added)
+	return added
+}
+
+// expand inlines the requested relations into docs. Documents that are not JSON
+// objects, and "" for missing ones, are passed through untouched. ids are the
+// ids of docs, "" where not known; they seed the cycle protection.
+func (r *prefixRoute) expand(ctx context.Context, docs []string, expansions []*expansion, ids []string) ([]string, error) {
+	nodes := make([]*expanded, 0, len(docs))
+	positions := make([]int, 0, len(docs))
+	for i, raw := range docs {
+		if doc, ok := parseObject(raw); ok {
+			node := &expanded{doc: doc}
+			if ids[i] != "" {
+				node.lineage = []string{r.prefix.URI + "/" + ids[i]}
+			}
+			nodes = append(nodes, node)
+			positions = append(positions, i)
+		}
+	}
+
+	if err := r.expandLevel(ctx, nodes, expansions); err != nil {
+		return nil, err
+	}
+
+	result := append([]string(nil), docs...)
+	for n, node := range nodes {
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(node.doc); err != nil {
+			return nil, err
+		}
+		result[positions[n]] = strings.TrimSuffix(buffer.String(), "\n")
+	}
+
+	return result, nil
+}
+
+// expandLevel resolves each relation for all nodes with one batch lookup on the
+// target prefix, then goes one level deeper with what it embedded.
+func (r *prefixRoute) expandLevel(ctx context.Context, nodes []*expanded, expansions []*expansion) error {
+	for _, exp := range expansions {
+		rel := r.relations[exp.name]
+
+		ids := make([]string, 0, len(nodes))
+		seen := make(map[string]bool, len(nodes))
+		for _, node := range nodes {
+			for _, id := range foreignIds(node.doc, rel.field) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		found, err := rel.target.redisService.GetByIds(ctx, ids)
+		if err != nil {
+			return err
+		}
+		byId := make(map[string]string, len(ids))
+		for i, id := range ids {
+			// the target's schema policy applies to embedded documents too
+			byId[id], _ = rel.target.queryService.Check(found[i])
+		}
+
+		var children []*expanded
+		embed := func(node *expanded, id string) (interface{}, bool) {
+			key := rel.target.prefix.URI + "/" + id
+			for _, ancestor := range node.lineage {
+				if ancestor == key {
+					return nil, false
+				}
+			}
+
+			// every parent gets its own copy, so lineages never mix
+			doc, ok := parseObject(byId[id])
+			if !ok {
+				return nil, true
+			}
+			lineage := append(append(make([]string, 0, len(node.lineage)+1), node.lineage...), key)
+			children = append(children, &expanded{doc: doc, lineage: lineage})
+			return doc, true
+		}
+
+		for _, node := range nodes {
+			switch value := lookupField(node.doc, rel.field).(type) {
+			case []interface{}:
+				embedded := make([]interface{}, 0, len(value))
+				for _, element := range value {
+					if id, ok := idString(element); ok {
+						if doc, ok := embed(node, id); ok {
+							embedded = append(embedded, doc)
+						}
+					}
+				}
+				node.doc[exp.name] = embedded
+			default:
+				if id, ok := idString(value); ok {
+					if doc, ok := embed(node, id); ok {
+						node.doc[exp.name] = doc
+					}
+				}
+			}
+		}
+
+		if len(exp.children) > 0 && len(children) > 0 {
+			if err := rel.target.expandLevel(ctx, children, exp.children); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseObject(raw string) (map[string]interface{}, bool) {
+	if raw == "" {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	// numbers are written back as they were stored
+	decoder.UseNumber()
+	doc := make(map[string]interface{})
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false
+	}
+
+	return doc, true
+}
+
+func lookupField(doc map[string]interface{}, field []string) interface{} {
+	var value interface{} = doc
+	for _, name := range field {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+
+	return value
+}
+
+// foreignIds returns the ids a field refers to, whether it holds one or an array.
+func foreignIds(doc map[string]interface{}, field []string) []string {
+	switch value := lookupField(doc, field).(type) {
+	case []interface{}:
+		ids := make([]string, 0, len(value))
+		for _, element := range value {
+			if id, ok := idString(element); ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	default:
+		if id, ok := idString(value); ok {
+			return []string{id}
+		}
+		return nil
+	}
+}
+
+func idString(value interface{}) (string, bool) {
+	switch id := value.(type) {
+	case string:
+		return id, id != ""
+	case json.Number:
+		return id.String(), true
+	default:
+		return "", false
+	}
+}
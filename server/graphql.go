@@ -0,0 +1,874 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	conf "redis-go-dispatcher/config"
+	"redis-go-dispatcher/service"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultGraphQLMaxDepth      = 10
+	defaultGraphQLMaxComplexity = 5000
+	// graphQLInferTimeout bounds the sampling of one schema build, a prefix that cannot be read only gets _document
+	graphQLInferTimeout = 5 * time.Second
+	// graphQLBuildBackoff is how long requests answer with the error of a failed first build before it is tried again
+	graphQLBuildBackoff = 5 * time.Second
+	// graphQLListFactor is the number of elements assumed per list when estimating
+	// the complexity of a query, lists are not paginated.
+	graphQLListFactor = 10
+)
+
+var graphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// graphQLJSON holds values without a single shape, such as the whole document.
+var graphQLJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+})
+
+// graphQLOperators are the filter arguments added per field, e.g. Year_gt, and their query operators.
+var graphQLOperators = map[*graphql.Scalar][]string{
+	graphql.Int:    {"gt", "gte", "lt", "lte"},
+	graphql.Float:  {"gt", "gte", "lt", "lte"},
+	graphql.String: {"ieq"},
+}
+
+// graphQLRequest is a GraphQL request as sent over HTTP.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLLimits bound the queries /graphql executes. Introspection does not count against them.
+type graphQLLimits struct {
+	// maxDepth is the deepest nesting of fields.
+	maxDepth int
+	// maxComplexity bounds the fields a query may resolve: every field costs one,
+	// what is selected below a list costs graphQLListFactor times as much.
+	maxComplexity int
+}
+
+// BuildGraphQLRouting serves /graphql. Its query type has a list field, e.g.
+// cars, and a by-id field, e.g. carsById, per prefix. Document fields come
+// from the prefix's schema, or are inferred from a sample of its documents.
+func BuildGraphQLRouting(e *echo.Echo, routes map[string]*prefixRoute) {
+	limits := graphQLLimits{maxDepth: config.GraphQL.MaxDepth, maxComplexity: config.GraphQL.MaxComplexity}
+	if limits.maxDepth == 0 {
+		limits.maxDepth = defaultGraphQLMaxDepth
+	}
+	if limits.maxComplexity == 0 {
+		limits.maxComplexity = defaultGraphQLMaxComplexity
+	}
+
+	schema := &graphQLSchema{
+		logger: e.Logger,
+		build: func() (*graphql.Schema, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), graphQLInferTimeout)
+			defer cancel()
+			return buildGraphQLSchema(ctx, config.Prefixes, routes, e.Logger)
+		},
+	}
+	for _, prefix := range config.Prefixes {
+		if prefix.Schema == "" {
+			schema.refresh = inferRefresh()
+		}
+	}
+
+	handler := func(c echo.Context) error {
+		request, err := readGraphQLRequest(c)
+		if err != nil {
+			return err
+		}
+		current, err := schema.current()
+		if err != nil {
+			return err
+		}
+
+		result := executeGraphQL(c.Request().Context(), current, request, limits)
+		// without data the request failed before execution, e.g. on a syntax error
+		if result.Data == nil {
+			return c.JSON(http.StatusBadRequest, result)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+	e.GET("/graphql", handler)
+	e.POST("/graphql", handler)
+}
+
+// readGraphQLRequest reads the query from the JSON body of a POST or the query parameters of a GET.
+func readGraphQLRequest(c echo.Context) (graphQLRequest, error) {
+	var request graphQLRequest
+	if c.Request().Method == http.MethodPost {
+		if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+			return request, echo.NewHTTPError(http.StatusBadRequest, "expected a JSON object with a query: "+err.Error())
+		}
+		return request, nil
+	}
+
+	request.Query = c.QueryParam("query")
+	request.OperationName = c.QueryParam("operationName")
+	if variables := c.QueryParam("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return request, echo.NewHTTPError(http.StatusBadRequest, "variables must be a JSON object: "+err.Error())
+		}
+	}
+
+	return request, nil
+}
+
+// executeGraphQL parses and validates the request, checks it against the limits and runs it.
+func executeGraphQL(ctx context.Context, schema *graphql.Schema, request graphQLRequest, limits graphQLLimits) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(request.Query), Name: "GraphQL request"})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(schema, document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	if operation := graphQLOperation(document, request.OperationName); operation != nil && operation.Operation == ast.OperationTypeQuery {
+		fragments := make(map[string]*ast.FragmentDefinition)
+		for _, definition := range document.Definitions {
+			if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+				fragments[fragment.Name.Value] = fragment
+			}
+		}
+
+		depth, complexity := graphQLCost(schema.QueryType(), operation.SelectionSet, 1, fragments)
+		if limits.maxDepth > 0 && depth > limits.maxDepth {
+			return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("Query depth %d exceeds the limit of %d.", depth, limits.maxDepth))}}
+		}
+		if limits.maxComplexity > 0 && complexity > limits.maxComplexity {
+			return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("Query complexity %d exceeds the limit of %d.", complexity, limits.maxComplexity))}}
+		}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        *schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       context.WithValue(ctx, graphQLLoaderKey{}, &graphQLLoader{batches: make(map[*prefixRoute]*graphQLBatch)}),
+	})
+}
+
+// graphQLOperation picks the operation to run, nil when the name matches none.
+func graphQLOperation(document *ast.Document, name string) *ast.OperationDefinition {
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			if name == "" || operation.Name != nil && operation.Name.Value == name {
+				return operation
+			}
+		}
+	}
+
+	return nil
+}
+
+// graphQLCost returns the depth of the deepest field below the selections and
+// their complexity. The document was validated, so fragments exist and do not
+// spread themselves.
+func graphQLCost(t *graphql.Object, selections *ast.SelectionSet, depth int, fragments map[string]*ast.FragmentDefinition) (int, int) {
+	maxDepth, complexity := 0, 0
+	add := func(d int, c int) {
+		if d > maxDepth {
+			maxDepth = d
+		}
+		complexity += c
+	}
+	if selections == nil {
+		return 0, 0
+	}
+
+	for _, selection := range selections.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			add(graphQLFieldCost(t, s, depth, fragments))
+		case *ast.FragmentSpread:
+			if fragment := fragments[s.Name.Value]; fragment != nil {
+				add(graphQLCost(t, fragment.SelectionSet, depth, fragments))
+			}
+		case *ast.InlineFragment:
+			// there are no interfaces or unions, fragments apply to t itself
+			add(graphQLCost(t, s.SelectionSet, depth, fragments))
+		}
+	}
+
+	return maxDepth, complexity
+}
+
+func graphQLFieldCost(t *graphql.Object, f *ast.Field, depth int, fragments map[string]*ast.FragmentDefinition) (int, int) {
+	definition := t.Fields()[f.Name.Value]
+	// introspection is free
+	if definition == nil || strings.HasPrefix(f.Name.Value, "__") {
+		return 0, 0
+	}
+
+	object, ok := graphql.GetNamed(definition.Type).(*graphql.Object)
+	if !ok {
+		return depth, 1
+	}
+
+	childDepth, childComplexity := graphQLCost(object, f.SelectionSet, depth+1, fragments)
+	fieldType := definition.Type
+	if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+		fieldType = nonNull.OfType
+	}
+	if _, isList := fieldType.(*graphql.List); isList {
+		childComplexity *= graphQLListFactor
+	}
+	if childDepth < depth {
+		childDepth = depth
+	}
+
+	return childDepth, 1 + childComplexity
+}
+
+// graphQLSchema builds the schema on first use and, when fields are inferred
+// from documents, rebuilds it in the background once refresh has passed.
+// Builds run outside the lock and one at a time, a failed first build is
+// retried after graphQLBuildBackoff.
+type graphQLSchema struct {
+	build   func() (*graphql.Schema, error)
+	logger  echo.Logger
+	refresh time.Duration
+	builds  singleflight.Group
+
+	mutex       sync.Mutex
+	schema      *graphql.Schema
+	attemptedAt time.Time
+	failure     error
+}
+
+func (s *graphQLSchema) current() (*graphql.Schema, error) {
+	s.mutex.Lock()
+	schema, attemptedAt, failure := s.schema, s.attemptedAt, s.failure
+	s.mutex.Unlock()
+
+	if schema == nil {
+		if failure != nil && time.Since(attemptedAt) < graphQLBuildBackoff {
+			return nil, failure
+		}
+		built, err, _ := s.builds.Do("", s.load)
+		if err != nil {
+			return nil, err
+		}
+		return built.(*graphql.Schema), nil
+	}
+
+	if s.refresh > 0 && time.Since(attemptedAt) > s.refresh {
+		// joins a rebuild already running, the current schema serves meanwhile
+		s.builds.DoChan("", s.load)
+	}
+	return schema, nil
+}
+
+func (s *graphQLSchema) load() (interface{}, error) {
+	schema, err := s.build()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attemptedAt = time.Now()
+	if err != nil {
+		// a previous schema keeps serving until the next attempt
+		s.failure = err
+		s.logger.Warnf("graphql: cannot build the schema: %v", err)
+		return nil, err
+	}
+	s.schema, s.failure = schema, nil
+
+	return schema, nil
+}
+
+type graphQLBuilder struct {
+	ctx    context.Context
+	routes map[string]*prefixRoute
+	logger echo.Logger
+	// objects holds the type of every prefix, by URI
+	objects map[string]*graphql.Object
+	// schemas holds the JSON Schema of every prefix, configured or inferred
+	schemas map[string]map[string]interface{}
+	names   map[string]bool
+}
+
+// buildGraphQLSchema maps the prefixes to a schema, ctx bounds the sampling of those without a JSON Schema.
+func buildGraphQLSchema(ctx context.Context, prefixes []conf.Prefix, routes map[string]*prefixRoute, logger echo.Logger) (*graphql.Schema, error) {
+	b := &graphQLBuilder{
+		ctx:     ctx,
+		routes:  routes,
+		logger:  logger,
+		objects: make(map[string]*graphql.Object, len(prefixes)),
+		schemas: make(map[string]map[string]interface{}, len(prefixes)),
+		names:   map[string]bool{"Query": true, graphQLJSON.Name(): true},
+	}
+
+	// relations may point at prefixes further down, so all types exist before any field
+	fields := make(map[string]graphql.Fields, len(prefixes))
+	for _, prefix := range prefixes {
+		uri := prefix.URI
+		b.schemas[uri] = b.documentSchema(prefix)
+		b.objects[uri] = graphql.NewObject(graphql.ObjectConfig{
+			Name:        b.typeName(graphQLWords(uri, true)),
+			Description: "A document of " + uri + ".",
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				return fields[uri]
+			}),
+		})
+	}
+
+	query := make(graphql.Fields, 2*len(prefixes))
+	for _, prefix := range prefixes {
+		route := routes[prefix.URI]
+		object := b.objects[prefix.URI]
+		objectFields := b.documentFields(object.Name(), b.schemas[prefix.URI], prefix.Relations)
+		for name, field := range b.relationFields(prefix) {
+			objectFields[name] = field
+		}
+		objectFields["_document"] = &graphql.Field{
+			Description: "The whole document as stored.",
+			Type:        graphQLJSON,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source, nil
+			},
+		}
+		fields[prefix.URI] = objectFields
+
+		name := graphQLWords(prefix.URI, false)
+		for query[name] != nil || query[name+"ById"] != nil {
+			name += "_"
+		}
+
+		pathArgs := make(graphql.FieldConfigArgument)
+		for _, param := range prefix.PathParams() {
+			pathArgs[param] = &graphql.ArgumentConfig{Description: "Path parameter of " + prefix.URI + ".", Type: graphql.NewNonNull(graphql.String)}
+		}
+		filters, listArgs := b.filterArguments(prefix, b.schemas[prefix.URI])
+		byIdArgs := graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}}
+		for param, arg := range pathArgs {
+			listArgs[param], byIdArgs[param] = arg, arg
+		}
+
+		query[name] = &graphql.Field{
+			Description: "The documents of " + prefix.URI + " matching the filter arguments.",
+			Args:        listArgs,
+			Type:        graphql.NewList(object),
+			Resolve:     route.graphQLList(filters),
+		}
+		query[name+"ById"] = &graphql.Field{
+			Description: "The document of " + prefix.URI + " with the id, null when there is none.",
+			Args:        byIdArgs,
+			Type:        object,
+			Resolve:     route.graphQLById,
+		}
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// documentSchema returns the configured schema of the prefix, or one inferred from a sample of its documents.
+func (b *graphQLBuilder) documentSchema(prefix conf.Prefix) map[string]interface{} {
+	schema, err := prefix.LoadSchema()
+	if err == nil && schema != nil {
+		return schema
+	}
+
+	inferred, err := b.routes[prefix.URI].inferSchema(b.ctx, inferRefresh())
+	if err != nil {
+		b.logger.Warnf("graphql: cannot infer the fields of %s: %v", prefix.URI, err)
+		return map[string]interface{}{}
+	}
+
+	return inferred
+}
+
+// typeName makes a type name unique, nested objects may collide with prefixes.
+func (b *graphQLBuilder) typeName(name string) string {
+	unique := name
+	for n := 2; b.names[unique]; n++ {
+		unique = name + strconv.Itoa(n)
+	}
+	b.names[unique] = true
+
+	return unique
+}
+
+// documentFields maps the properties of an object schema to fields. Properties
+// named like a relation, or without a valid GraphQL name, are left to _document.
+func (b *graphQLBuilder) documentFields(typeName string, schema map[string]interface{}, relations map[string]conf.Relation) graphql.Fields {
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		if _, isRelation := relations[name]; !isRelation && graphQLName.MatchString(name) && !strings.HasPrefix(name, "__") && name != "_document" {
+			names = append(names, name)
+		}
+	}
+	// nested type names are numbered in order
+	sort.Strings(names)
+
+	fields := make(graphql.Fields, len(names))
+	for _, name := range names {
+		propertySchema, _ := properties[name].(map[string]interface{})
+		name := name
+		fields[name] = &graphql.Field{
+			Description: description(propertySchema),
+			Type:        b.outputType(typeName+graphQLWords(name, true), propertySchema),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if doc, ok := p.Source.(map[string]interface{}); ok {
+					return graphQLValue(doc[name]), nil
+				}
+				return nil, nil
+			},
+		}
+	}
+
+	return fields
+}
+
+// graphQLValue turns the numbers of a document, kept as stored, into ones the scalars serialize.
+func graphQLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			values[i] = graphQLValue(element)
+		}
+		return values
+	default:
+		return value
+	}
+}
+
+// outputType maps a JSON Schema to a GraphQL type, JSON when it has no single shape.
+func (b *graphQLBuilder) outputType(name string, schema map[string]interface{}) graphql.Output {
+	if scalar := scalarType(schema); scalar != nil {
+		return scalar
+	}
+
+	switch schemaType(schema) {
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		return graphql.NewList(b.outputType(name, items))
+	case "object":
+		fields := b.documentFields(name, schema, nil)
+		if len(fields) == 0 {
+			return graphQLJSON
+		}
+		return graphql.NewObject(graphql.ObjectConfig{Name: b.typeName(name), Description: description(schema), Fields: fields})
+	default:
+		return graphQLJSON
+	}
+}
+
+// scalarType maps a schema of strings, numbers or booleans to its scalar, nil for other schemas.
+func scalarType(schema map[string]interface{}) *graphql.Scalar {
+	switch schemaType(schema) {
+	case "string":
+		return graphql.String
+	case "integer":
+		return graphql.Int
+	case "number":
+		return graphql.Float
+	case "boolean":
+		return graphql.Boolean
+	default:
+		return nil
+	}
+}
+
+// schemaType returns the single type of a schema, ignoring null, or "".
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		single := ""
+		for _, name := range t {
+			if name == "null" {
+				continue
+			}
+			if single != "" {
+				return ""
+			}
+			single, _ = name.(string)
+		}
+		return single
+	default:
+		if _, found := schema["properties"]; found {
+			return "object"
+		}
+		return ""
+	}
+}
+
+func description(schema map[string]interface{}) string {
+	text, _ := schema["description"].(string)
+	return text
+}
+
+// relationFields resolve the relations of the prefix, with one lookup per
+// relation target and level of the response.
+func (b *graphQLBuilder) relationFields(prefix conf.Prefix) graphql.Fields {
+	route := b.routes[prefix.URI]
+	fields := make(graphql.Fields, len(prefix.Relations))
+	for name, rel := range prefix.Relations {
+		if !graphQLName.MatchString(name) || strings.HasPrefix(name, "__") || name == "_document" {
+			continue
+		}
+
+		var fieldType graphql.Output = b.objects[rel.Prefix]
+		many := isArrayField(b.schemas[prefix.URI], strings.Split(rel.Field, "."))
+		if many {
+			fieldType = graphql.NewList(fieldType)
+		}
+		fields[name] = &graphql.Field{
+			Description: fmt.Sprintf("The document of %s referred to by %s.", rel.Prefix, rel.Field),
+			Type:        fieldType,
+			Resolve:     graphQLRelation(route.relations[name], many),
+		}
+	}
+
+	return fields
+}
+
+// isArrayField tells whether the schema declares the field an array.
+func isArrayField(schema map[string]interface{}, field []string) bool {
+	for _, name := range field {
+		properties, _ := schema["properties"].(map[string]interface{})
+		if schema, _ = properties[name].(map[string]interface{}); schema == nil {
+			return false
+		}
+	}
+
+	return schemaType(schema) == "array"
+}
+
+// filterArguments returns the filter arguments of the list field and the query
+// parameter each one maps to. Top level strings, numbers and booleans compare
+// for equality, several values match any of them, null matches null; numbers
+// also get _gt, _gte, _lt and _lte and strings _ieq.
+func (b *graphQLBuilder) filterArguments(prefix conf.Prefix, schema map[string]interface{}) (map[string]string, graphql.FieldConfigArgument) {
+	filters := map[string]string{service.ParamFilter: service.ParamFilter}
+	args := graphql.FieldConfigArgument{service.ParamFilter: {Description: "A boolean filter expression, e.g. `Year > 2015 AND NOT Model = \"Golf\"`.", Type: graphql.String}}
+	if len(prefix.SearchFields) > 0 {
+		filters[service.ParamSearch] = service.ParamSearch
+		args[service.ParamSearch] = &graphql.ArgumentConfig{Description: "Free text searched in " + strings.Join(prefix.SearchFields, ", ") + ".", Type: graphql.String}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pathParams := make(map[string]bool)
+	for _, param := range prefix.PathParams() {
+		pathParams[param] = true
+	}
+	add := func(name string, param string, t graphql.Input, text string) {
+		if _, taken := filters[name]; !taken && !pathParams[name] && name != "id" {
+			filters[name] = param
+			args[name] = &graphql.ArgumentConfig{Description: text, Type: t}
+		}
+	}
+
+	for _, name := range names {
+		propertySchema, _ := properties[name].(map[string]interface{})
+		t := scalarType(propertySchema)
+		if t == nil || !graphQLName.MatchString(name) || strings.HasPrefix(name, "__") {
+			continue
+		}
+
+		add(name, name, graphql.NewList(t), "Documents whose "+name+" is one of the values.")
+		for _, operator := range graphQLOperators[t] {
+			argType := t
+			if t == graphql.Int {
+				argType = graphql.Float
+			}
+			add(name+"_"+operator, name+"["+operator+"]", argType, "Documents whose "+name+" compares "+operator+" to the value.")
+		}
+	}
+
+	return filters, args
+}
+
+// graphQLWords joins the words of a URI or field name in camel case, e.g.
+// /tenants/:tenant/orders gives tenantsTenantOrders, or TenantsTenantOrders when upper.
+func graphQLWords(text string, upper bool) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) || r > unicode.MaxASCII
+	})
+
+	var joined strings.Builder
+	for i, word := range words {
+		if i > 0 || upper {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		} else {
+			word = strings.ToLower(word[:1]) + word[1:]
+		}
+		joined.WriteString(word)
+	}
+
+	name := joined.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// graphQLSource returns the service the query reads, bound to the path parameters among args.
+func (r *prefixRoute) graphQLSource(args map[string]interface{}) (RedisService, error) {
+	if r.keyspace == nil {
+		return r.redisService, nil
+	}
+
+	params := make(map[string]string)
+	for _, param := range r.prefix.PathParams() {
+		params[param], _ = args[param].(string)
+	}
+
+	bound, err := r.keyspace.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return bound, nil
+}
+
+func (r *prefixRoute) graphQLList(filters map[string]string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		source, err := r.graphQLSource(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		queryParams := make(map[string][]string)
+		for name, value := range p.Args {
+			param, isFilter := filters[name]
+			if !isFilter {
+				continue
+			}
+			switch value := value.(type) {
+			case nil:
+				// an explicit null leaves the argument unset, [null] matches null
+			case []interface{}:
+				for _, element := range value {
+					queryParams[param] = append(queryParams[param], graphQLParameter(element))
+				}
+			default:
+				queryParams[param] = append(queryParams[param], graphQLParameter(value))
+			}
+		}
+
+		docs, err := documents(p.Context, source)
+		if err != nil {
+			return nil, err
+		}
+		result, _, err := r.queryService.Query(p.Context, queryParams, docs)
+		if err != nil {
+			return nil, err
+		}
+
+		list := make([]interface{}, 0, len(result))
+		for _, raw := range result {
+			// kept under invalid_json include, they only show in _document
+			if doc, ok := parseObject(raw); ok {
+				list = append(list, doc)
+			}
+		}
+		return list, nil
+	}
+}
+
+// graphQLParameter renders an argument as a query parameter value.
+func graphQLParameter(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (r *prefixRoute) graphQLById(p graphql.ResolveParams) (interface{}, error) {
+	source, err := r.graphQLSource(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	id := p.Args["id"].(string)
+	found, err := r.graphQLLoad(p.Context, source, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if doc, ok := found[id]; ok {
+		return doc, nil
+	}
+	return nil, nil
+}
+
+// graphQLLoad reads documents by id in one lookup, applying the schema policy.
+// Missing documents and those that are not JSON objects are left out.
+func (r *prefixRoute) graphQLLoad(ctx context.Context, source RedisService, ids []string) (map[string]map[string]interface{}, error) {
+	raws, err := source.GetByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(map[string]map[string]interface{}, len(ids))
+	for i, raw := range raws {
+		if raw == "" {
+			continue
+		}
+		if raw, keep := r.queryService.Check(raw); keep {
+			if doc, ok := parseObject(raw); ok {
+				docs[ids[i]] = doc
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+type graphQLLoaderKey struct{}
+
+// graphQLLoader batches the relation lookups of one request. Relation fields
+// register the ids they need and resolve to thunks; the executor runs the
+// thunks of a level after resolving all of its fields, so the first thunk reads
+// the ids of the whole level at once. Execution is sequential, nothing is locked.
+type graphQLLoader struct {
+	batches map[*prefixRoute]*graphQLBatch
+}
+
+// graphQLBatch holds the documents of one relation target read so far, nil for missing ones.
+type graphQLBatch struct {
+	pending []string
+	docs    map[string]map[string]interface{}
+	errs    map[string]error
+}
+
+func (l *graphQLLoader) batch(target *prefixRoute) *graphQLBatch {
+	b, found := l.batches[target]
+	if !found {
+		b = &graphQLBatch{docs: make(map[string]map[string]interface{}), errs: make(map[string]error)}
+		l.batches[target] = b
+	}
+
+	return b
+}
+
+func (l *graphQLLoader) want(target *prefixRoute, ids []string) {
+	b := l.batch(target)
+	b.pending = append(b.pending, ids...)
+}
+
+// load reads what is pending for target and returns its documents, failing when one of ids could not be read.
+func (l *graphQLLoader) load(ctx context.Context, target *prefixRoute, ids []string) (map[string]map[string]interface{}, error) {
+	b := l.batch(target)
+	if len(b.pending) > 0 {
+		var unread []string
+		seen := make(map[string]bool, len(b.pending))
+		for _, id := range b.pending {
+			if _, read := b.docs[id]; !read && b.errs[id] == nil && !seen[id] {
+				seen[id] = true
+				unread = append(unread, id)
+			}
+		}
+		b.pending = nil
+
+		if len(unread) > 0 {
+			found, err := target.graphQLLoad(ctx, target.redisService, unread)
+			for _, id := range unread {
+				if err != nil {
+					b.errs[id] = err
+				} else {
+					b.docs[id] = found[id]
+				}
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if err := b.errs[id]; err != nil {
+			return nil, err
+		}
+	}
+	return b.docs, nil
+}
+
+// graphQLRelation resolves a relation through the loader of the request.
+func graphQLRelation(rel relation, many bool) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		doc, _ := p.Source.(map[string]interface{})
+		ids := foreignIds(doc, rel.field)
+		if len(ids) == 0 {
+			if many {
+				return []interface{}{}, nil
+			}
+			return nil, nil
+		}
+
+		loader := p.Context.Value(graphQLLoaderKey{}).(*graphQLLoader)
+		loader.want(rel.target, ids)
+		return func() (interface{}, error) {
+			docs, err := loader.load(p.Context, rel.target, ids)
+			if err != nil {
+				return nil, err
+			}
+
+			related := make([]interface{}, 0, len(ids))
+			for _, id := range ids {
+				if target := docs[id]; target != nil {
+					related = append(related, target)
+				}
+			}
+			if many {
+				return related, nil
+			}
+			if len(related) > 0 {
+				return related[0], nil
+			}
+			return nil, nil
+		}, nil
+	}
+}
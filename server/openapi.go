@@ -0,0 +1,425 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"net/http"
+	conf "redis-go-dispatcher/config"
+	"redis-go-dispatcher/service"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// docsPage renders /_openapi.json with the Swagger UI assets served under /_docs/assets.
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>redis-go-dispatcher API</title>
+  <link rel="stylesheet" href="/_docs/assets/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/_docs/assets/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({url: "/_openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// paramInfer=true describes the documents of prefixes without a schema by a
+// sample of them, sampled at most once per graphql.infer_refresh.
+const paramInfer = "infer"
+
+// componentUnsafe matches what may not appear in a component name.
+var componentUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+const filterDescription = "Any other parameter filters on a field path, e.g. `color=red` or `owner.name=Alice`. " +
+	"Values compare by the JSON type of the field: numbers numerically, `null` matches null and objects match by content. " +
+	"Repeating a parameter matches any of its values. A path may end in an operator: " +
+	"`year[gt]`, `year[gte]`, `year[lt]` and `year[lte]` take numbers, `name[ieq]` compares strings ignoring case. " +
+	"Arrays match when any element does; `wheels[0].size` picks an element, `wheels[all].size`, `wheels[any].size` " +
+	"and `wheels[none].size` quantify over the elements and `tags[len]` is the length of an array."
+
+// BuildDocsRouting serves the OpenAPI document of the configured prefixes and a page rendering it.
+func BuildDocsRouting(e *echo.Echo, routes map[string]*prefixRoute) {
+	document, err := json.Marshal(openAPIDocument(config.Prefixes, nil))
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	e.GET("/_openapi.json", func(c echo.Context) error {
+		switch c.QueryParam(paramInfer) {
+		case "":
+			return c.JSONBlob(http.StatusOK, document)
+		case "true":
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, paramInfer+" must be true")
+		}
+
+		inferred := make(map[string]map[string]interface{})
+		for _, prefix := range config.Prefixes {
+			if prefix.Schema != "" {
+				continue
+			}
+			schema, err := routes[prefix.URI].inferSchema(c.Request().Context(), inferRefresh())
+			if err != nil {
+				return err
+			}
+			inferred[prefix.URI] = schema
+		}
+		return c.JSON(http.StatusOK, openAPIDocument(config.Prefixes, inferred))
+	})
+	e.GET("/_docs", func(c echo.Context) error {
+		return c.HTML(http.StatusOK, docsPage)
+	})
+	e.StaticFS("/_docs/assets", swaggerFiles.FS)
+}
+
+// openAPIDocument describes the prefixes. Those without a configured schema
+// take theirs from inferred, or accept any object.
+func openAPIDocument(prefixes []conf.Prefix, inferred map[string]map[string]interface{}) map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"message":  map[string]interface{}{"type": "string"},
+				"position": map[string]interface{}{"type": "integer", "description": "Where a " + service.ParamFilter + " expression went wrong, counting from 1."},
+			},
+		},
+	}
+	paths := make(map[string]interface{})
+	for _, prefix := range prefixes {
+		name := uniqueName(schemaName(prefix.URI), schemas)
+		schema, err := prefix.LoadSchema()
+		if err != nil || schema == nil {
+			schema = inferred[prefix.URI]
+		}
+		if schema == nil {
+			// the configuration was validated, no schema just means anything goes
+			schema = map[string]interface{}{"type": "object"}
+		}
+		schemas[name] = relocate(schema, "#/components/schemas/"+name)
+
+		base := openAPIPath(prefix.URI)
+		pathParams := make([]interface{}, 0)
+		for _, param := range prefix.PathParams() {
+			pathParams = append(pathParams, pathParameter(param))
+		}
+		document := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+
+		paths[base] = map[string]interface{}{
+			"parameters": pathParams,
+			"get":        collectionOperation(prefix, name, document),
+		}
+		id := pathParameter("id")
+		id["description"] = "The document id. _batch, _aggregate, _facets and _validate name endpoints, documents with such ids are read with ?_ids=."
+		paths[base+"/{id}"] = map[string]interface{}{
+			"parameters": append([]interface{}{id}, pathParams...),
+			"get":        itemOperation(prefix, name, document),
+		}
+		paths[base+"/_batch"] = map[string]interface{}{
+			"parameters": pathParams,
+			"post":       batchOperation(prefix, name, document),
+		}
+		paths[base+"/_aggregate"] = map[string]interface{}{
+			"parameters": pathParams,
+			"get":        aggregateOperation(prefix, name),
+		}
+		paths[base+"/_facets"] = map[string]interface{}{
+			"parameters": pathParams,
+			"get":        facetsOperation(prefix, name),
+		}
+		if prefix.Schema != "" {
+			paths[base+"/_validate"] = map[string]interface{}{
+				"parameters": pathParams,
+				"post":       validateOperation(prefix, name, document),
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "redis-go-dispatcher",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// openAPIPath turns echo's :param segments into {param}.
+func openAPIPath(uri string) string {
+	segments := strings.Split(uri, "/")
+	for i, segment := range segments {
+		if name, found := strings.CutPrefix(segment, ":"); found {
+			segments[i] = "{" + name + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// schemaName derives a component name from a prefix URI, /tenants/:tenant/orders becomes tenants_tenant_orders.
+func schemaName(uri string) string {
+	return strings.Trim(componentUnsafe.ReplaceAllString(strings.ReplaceAll(uri, ":", ""), "_"), "_")
+}
+
+// uniqueName numbers a component name taken already, /a/b and /a_b would both be a_b.
+func uniqueName(name string, taken map[string]interface{}) string {
+	unique := name
+	for n := 2; taken[unique] != nil; n++ {
+		unique = name + "_" + strconv.Itoa(n)
+	}
+
+	return unique
+}
+
+// relocate copies a schema to the component at base, pointing its local refs
+// such as #/$defs/wheel into the component.
+func relocate(schema map[string]interface{}, base string) map[string]interface{} {
+	relocated := relocateSchema(schema, base).(map[string]interface{})
+	// without an $id of its own the component resolves refs against the document
+	delete(relocated, "$id")
+
+	return relocated
+}
+
+func relocateSchema(value interface{}, base string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			switch key {
+			case "$ref", "$dynamicRef":
+				if ref, ok := child.(string); ok && (ref == "#" || strings.HasPrefix(ref, "#/")) {
+					child = base + strings.TrimPrefix(ref, "#")
+				}
+			case "enum", "const", "default", "examples", "example":
+				// values, not schemas
+			case "properties", "patternProperties", "$defs", "definitions", "dependentSchemas":
+				// keyed by names that may look like keywords
+				if named, ok := child.(map[string]interface{}); ok {
+					schemas := make(map[string]interface{}, len(named))
+					for name, schema := range named {
+						schemas[name] = relocateSchema(schema, base)
+					}
+					child = schemas
+				}
+			default:
+				child = relocateSchema(child, base)
+			}
+			result[key] = child
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, element := range v {
+			result[i] = relocateSchema(element, base)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func pathParameter(name string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func queryParameter(name string, description string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func filterParameters(prefix conf.Prefix) []interface{} {
+	parameters := []interface{}{
+		map[string]interface{}{
+			"name":        "filters",
+			"in":          "query",
+			"description": filterDescription,
+			"style":       "form",
+			"explode":     true,
+			"schema": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+		},
+		queryParameter(service.ParamFilter, "A boolean expression such as `color = \"red\" OR (price < 100 AND NOT brand = \"X\")`, "+
+			"with the comparisons `= != < <= > >=` and the functions `contains`, `startsWith` and `endsWith`."),
+	}
+	if len(prefix.SearchFields) > 0 {
+		parameters = append(parameters, queryParameter(service.ParamSearch,
+			"Free text searched in "+strings.Join(prefix.SearchFields, ", ")+". Every word has to occur, results are sorted by relevance."))
+	}
+
+	return parameters
+}
+
+func expandParameter(prefix conf.Prefix) []interface{} {
+	if len(prefix.Relations) == 0 {
+		return []interface{}{}
+	}
+
+	names := make([]string, 0, len(prefix.Relations))
+	for name := range prefix.Relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return []interface{}{queryParameter(paramExpand, fmt.Sprintf(
+		"Comma separated relations to embed, nested ones dotted up to %d levels. Relations: %s.", maxExpandDepth, strings.Join(names, ", ")))}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// errorResponses are the failures every operation may answer with.
+func errorResponses(responses map[string]interface{}) map[string]interface{} {
+	errorBody := jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"})
+	responses["400"] = map[string]interface{}{"description": "Invalid query parameters or path parameters.", "content": errorBody}
+	responses["502"] = map[string]interface{}{"description": "A stored document is not valid JSON and the prefix fails on those.", "content": errorBody}
+	responses["503"] = map[string]interface{}{"description": "Redis is unavailable.", "content": errorBody}
+	responses["504"] = map[string]interface{}{"description": "The request timed out.", "content": errorBody}
+
+	return responses
+}
+
+func collectionOperation(prefix conf.Prefix, name string, document interface{}) map[string]interface{} {
+	parameters := filterParameters(prefix)
+	parameters = append(parameters, expandParameter(prefix)...)
+	parameters = append(parameters, queryParameter(paramIds, fmt.Sprintf(
+		"Comma separated ids to read, at most %d. Cannot be combined with filters; missing documents are null.", maxBatchIds)))
+
+	return map[string]interface{}{
+		"operationId": "list_" + name,
+		"summary":     "List the documents of " + prefix.URI,
+		"parameters":  parameters,
+		"responses": errorResponses(map[string]interface{}{
+			"200": map[string]interface{}{"description": "The matching documents.", "content": jsonContent(map[string]interface{}{"type": "array", "items": document})},
+			"304": map[string]interface{}{"description": "Not modified since the validator sent in If-None-Match or If-Modified-Since."},
+		}),
+	}
+}
+
+func itemOperation(prefix conf.Prefix, name string, document interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"operationId": "get_" + name,
+		"summary":     "Read one document of " + prefix.URI,
+		"parameters":  expandParameter(prefix),
+		"responses": errorResponses(map[string]interface{}{
+			"200": map[string]interface{}{"description": "The document.", "content": jsonContent(document)},
+			"304": map[string]interface{}{"description": "Not modified since the validator sent in If-None-Match or If-Modified-Since."},
+			"404": map[string]interface{}{"description": "No document with this id."},
+		}),
+	}
+}
+
+func batchOperation(prefix conf.Prefix, name string, document interface{}) map[string]interface{} {
+	ids := map[string]interface{}{
+		"type":     "array",
+		"maxItems": maxBatchIds,
+		"items":    map[string]interface{}{"type": []string{"string", "number"}},
+	}
+
+	return map[string]interface{}{
+		"operationId": "batch_" + name,
+		"summary":     "Read several documents of " + prefix.URI + " by id",
+		"parameters":  expandParameter(prefix),
+		"requestBody": map[string]interface{}{"required": true, "content": jsonContent(ids)},
+		"responses": errorResponses(map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "The documents in the order of the ids, null for missing ones.",
+				"content": jsonContent(map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"oneOf": []interface{}{document, map[string]interface{}{"type": "null"}}},
+				}),
+			},
+		}),
+	}
+}
+
+func aggregateOperation(prefix conf.Prefix, name string) map[string]interface{} {
+	parameters := append(filterParameters(prefix),
+		queryParameter(service.ParamGroupBy, "Comma separated field paths to group by. Documents without a value fall into the null group."),
+		queryParameter(service.ParamMetrics, "Comma separated metrics: `count`, `sum(field)`, `avg(field)`, `min(field)` and `max(field)`. Defaults to count."),
+	)
+	bucket := map[string]interface{}{"type": "object", "description": "The group values and the metrics, keyed as requested."}
+
+	return map[string]interface{}{
+		"operationId": "aggregate_" + name,
+		"summary":     "Aggregate the documents of " + prefix.URI,
+		"parameters":  parameters,
+		"responses": errorResponses(map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "A single bucket without " + service.ParamGroupBy + ", the buckets ordered by group values with it.",
+				"content":     jsonContent(map[string]interface{}{"oneOf": []interface{}{bucket, map[string]interface{}{"type": "array", "items": bucket}}}),
+			},
+		}),
+	}
+}
+
+func facetsOperation(prefix conf.Prefix, name string) map[string]interface{} {
+	fields := queryParameter(service.ParamFields, "Comma separated field paths to count the values of.")
+	fields["required"] = true
+	limit := queryParameter(service.ParamLimit, "Keep the N most frequent values per field.")
+	limit["schema"] = map[string]interface{}{"type": "integer", "minimum": 1}
+	value := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"value": map[string]interface{}{"type": []string{"string", "number", "boolean"}},
+			"count": map[string]interface{}{"type": "integer"},
+		},
+	}
+
+	return map[string]interface{}{
+		"operationId": "facets_" + name,
+		"summary":     "Count the distinct values of fields of " + prefix.URI,
+		"parameters":  append(filterParameters(prefix), fields, limit),
+		"responses": errorResponses(map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Per field, its values, most frequent first.",
+				"content": jsonContent(map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "array", "items": value},
+				}),
+			},
+		}),
+	}
+}
+
+func validateOperation(prefix conf.Prefix, name string, document interface{}) map[string]interface{} {
+	failure := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"},
+			"errors": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"path":    map[string]interface{}{"type": "string", "description": "JSON pointer to the offending value."},
+						"message": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"operationId": "validate_" + name,
+		"summary":     "Check a document against the schema of " + prefix.URI + " before storing it",
+		"requestBody": map[string]interface{}{"required": true, "content": jsonContent(document)},
+		"responses": map[string]interface{}{
+			"204": map[string]interface{}{"description": "The document matches the schema."},
+			"400": map[string]interface{}{"description": "The body is not JSON.", "content": jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"})},
+			"422": map[string]interface{}{"description": "The document violates the schema.", "content": jsonContent(failure)},
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"redis-go-dispatcher/service"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// paramSample is the number of documents profiled, e.g. ?sample=500.
+	paramSample = "sample"
+	// paramFormat=schema answers with the inferred JSON Schema instead of the profile.
+	paramFormat = "format"
+
+	defaultProfileSample = 1000
+	maxProfileSample     = 10000
+
+	// inferSample is the number of documents read to infer the schema of a prefix without one
+	inferSample = 100
+	// defaultInferRefresh is how long an inferred schema is kept before the documents are sampled again
+	defaultInferRefresh = time.Minute
+)
+
+// inferredSchema keeps the schema inferred for a prefix without one, shared
+// by /graphql and /_openapi.json?infer=true.
+type inferredSchema struct {
+	loads singleflight.Group
+
+	mutex      sync.Mutex
+	schema     map[string]interface{}
+	inferredAt time.Time
+}
+
+// inferRefresh is how long inferred schemas are kept, graphql.infer_refresh or a minute.
+func inferRefresh() time.Duration {
+	if config.GraphQL.InferRefresh > 0 {
+		return config.GraphQL.InferRefresh
+	}
+
+	return defaultInferRefresh
+}
+
+// handleProfile serves GET /_admin/prefixes/<prefix>/profile, e.g.
+// /_admin/prefixes/cars/profile. It profiles a random sample of the stored
+// documents, bypassing the cache.
+func (r *prefixRoute) handleProfile(c echo.Context) error {
+	size := defaultProfileSample
+	if value := c.QueryParam(paramSample); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size < 1 || size > maxProfileSample {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be a number between 1 and %d", paramSample, maxProfileSample))
+		}
+	}
+	format := c.QueryParam(paramFormat)
+	if format != "" && format != "schema" {
+		return echo.NewHTTPError(http.StatusBadRequest, paramFormat+" must be schema")
+	}
+
+	source, err := r.sourceFor(c)
+	if err != nil {
+		return err
+	}
+	profile, err := service.ProfileDocuments(c.Request().Context(), r.prefix.URI, source, size)
+	if err != nil {
+		return err
+	}
+
+	if format == "schema" {
+		return c.JSON(http.StatusOK, profile.Schema())
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// inferSchema returns a JSON Schema inferred from a sample of the documents of
+// the prefix. Unbound path parameters match every value. A schema inferred
+// less than maxAge ago is reused and concurrent callers share one sampling.
+func (r *prefixRoute) inferSchema(ctx context.Context, maxAge time.Duration) (map[string]interface{}, error) {
+	r.inferred.mutex.Lock()
+	schema, inferredAt := r.inferred.schema, r.inferred.inferredAt
+	r.inferred.mutex.Unlock()
+	if schema != nil && time.Since(inferredAt) < maxAge {
+		return schema, nil
+	}
+
+	inferred, err, _ := r.inferred.loads.Do("", func() (interface{}, error) {
+		source := r.source
+		if source == nil {
+			source = r.keyspace
+		}
+		profile, err := service.ProfileDocuments(ctx, r.prefix.URI, source, inferSample)
+		if err != nil {
+			return nil, err
+		}
+
+		schema := profile.Schema()
+		r.inferred.mutex.Lock()
+		r.inferred.schema, r.inferred.inferredAt = schema, time.Now()
+		r.inferred.mutex.Unlock()
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inferred.(map[string]interface{}), nil
+}
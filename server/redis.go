@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	conf "redis-go-dispatcher/config"
+	"redis-go-dispatcher/service"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultFailureThreshold    = 5
+	defaultOpenTimeout         = 10 * time.Second
+)
+
+func newRedisBackends(loadedConfig conf.Config) (map[string]*service.RedisBackend, error) {
+	backends := make(map[string]*service.RedisBackend)
+	for name, redisConfig := range loadedConfig.Backends() {
+		backend, err := newRedisBackend(name, redisConfig)
+		if err != nil {
+			return nil, fmt.Errorf("redis backend %s: %w", name, err)
+		}
+		backends[name] = backend
+	}
+
+	return backends, nil
+}
+
+func newRedisBackend(name string, redisConfig conf.RedisConfig) (*service.RedisBackend, error) {
+	primary, err := newRedisPool(redisConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]*redis.Pool, 0, len(redisConfig.Replicas))
+	for _, replicaURL := range redisConfig.Replicas {
+		replicaConfig := redisConfig
+		replicaConfig.URL = replicaURL
+		replica, err := newRedisPool(replicaConfig)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+
+	interval := redisConfig.HealthCheckInterval
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	backend := service.NewRedisBackend(name, primary, replicas, breakerSettings(redisConfig.CircuitBreaker))
+	backend.StartHealthChecks(interval)
+
+	return backend, nil
+}
+
+func breakerSettings(breakerConfig conf.CircuitBreakerConfig) service.BreakerSettings {
+	if breakerConfig.Disabled {
+		return service.BreakerSettings{}
+	}
+
+	settings := service.BreakerSettings{
+		FailureThreshold: breakerConfig.FailureThreshold,
+		OpenTimeout:      breakerConfig.OpenTimeout,
+	}
+	if settings.FailureThreshold == 0 {
+		settings.FailureThreshold = defaultFailureThreshold
+	}
+	if settings.OpenTimeout == 0 {
+		settings.OpenTimeout = defaultOpenTimeout
+	}
+
+	return settings
+}
+
+func newRedisPool(redisConfig conf.RedisConfig) (*redis.Pool, error) {
+	address, options, err := dialOptions(redisConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redis.Pool{
+		MaxIdle:         redisConfig.PoolMaxIdle,
+		MaxActive:       redisConfig.PoolMaxActive,
+		IdleTimeout:     redisConfig.IdleTimeout,
+		MaxConnLifetime: redisConfig.MaxConnLifetime,
+		Wait:            redisConfig.Wait,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, options...)
+		},
+	}, nil
+}
+
+// pathDB matches the database of a redis URL path, as redigo's DialURL does.
+var pathDB = regexp.MustCompile(`/(\d*)\z`)
+
+// dialOptions merges credentials and database from the URL with the explicit
+// config fields. The URL is read the way redigo's DialURL reads it, explicit
+// fields win over it.
+func dialOptions(redisConfig conf.RedisConfig) (string, []redis.DialOption, error) {
+	u, err := url.Parse(redisConfig.URL)
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return "", nil, fmt.Errorf("invalid redis URL scheme: %s", u.Scheme)
+	}
+	if u.Opaque != "" {
+		return "", nil, fmt.Errorf("invalid redis URL, url is opaque: %s", redisConfig.URL)
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+		port = "6379"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	username, password, db := "", "", 0
+	if u.User != nil {
+		if pass, isSet := u.User.Password(); isSet {
+			// user:pass is an ACL login, :pass alone a requirepass password
+			username, password = u.User.Username(), pass
+		} else {
+			// a lone user-info is the requirepass password, as with redis-cli
+			password = u.User.Username()
+		}
+	}
+	if match := pathDB.FindStringSubmatch(u.Path); len(match) == 2 {
+		if match[1] != "" {
+			if db, err = strconv.Atoi(match[1]); err != nil {
+				return "", nil, fmt.Errorf("invalid database in redis url: %s", u.Path[1:])
+			}
+		}
+	} else if u.Path != "" {
+		return "", nil, fmt.Errorf("invalid database in redis url: %s", u.Path[1:])
+	}
+
+	if redisConfig.Username != "" {
+		username = redisConfig.Username
+	}
+	if redisConfig.Password != "" {
+		password = redisConfig.Password
+	}
+	if redisConfig.PasswordFile != "" {
+		data, err := os.ReadFile(redisConfig.PasswordFile)
+		if err != nil {
+			return "", nil, err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if redisConfig.DB != nil {
+		db = *redisConfig.DB
+	}
+
+	options := []redis.DialOption{
+		redis.DialReadTimeout(redisConfig.ReadTimeout),
+		redis.DialWriteTimeout(redisConfig.WriteTimeout),
+		redis.DialDatabase(db),
+	}
+	if redisConfig.ConnectTimeout > 0 {
+		// redigo falls back to its own 30s default when the option is absent
+		options = append(options, redis.DialConnectTimeout(redisConfig.ConnectTimeout))
+	}
+	if username != "" {
+		options = append(options, redis.DialUsername(username))
+	}
+	if password != "" {
+		options = append(options, redis.DialPassword(password))
+	}
+
+	if redisConfig.UseTLS() {
+		tlsConfig, err := buildTLSConfig(redisConfig.TLS)
+		if err != nil {
+			return "", nil, err
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		options = append(options,
+			redis.DialUseTLS(true),
+			redis.DialTLSConfig(tlsConfig),
+			redis.DialTLSSkipVerify(redisConfig.TLS.InsecureSkipVerify),
+		)
+	}
+
+	return net.JoinHostPort(host, port), options, nil
+}
+
+func buildTLSConfig(tlsConfig conf.TLSConfig) (*tls.Config, error) {
+	result := &tls.Config{
+		ServerName: tlsConfig.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if tlsConfig.CAFile != "" {
+		pem, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + tlsConfig.CAFile)
+		}
+		result.RootCAs = pool
+	}
+
+	if tlsConfig.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	return result, nil
+}
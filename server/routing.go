@@ -1,55 +1,280 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"net/url"
+	"path"
 	conf "redis-go-dispatcher/config"
+	"redis-go-dispatcher/schema"
 	"redis-go-dispatcher/service"
-	"strings"
+	"slices"
+	"strconv"
+	"time"
 )
 
+// statusClientClosedRequest is the nginx convention for requests the client abandoned.
+const statusClientClosedRequest = 499
+
 type RedisService interface {
-	GetAll() ([]string, error)
-	GetById(id string) (string, error)
+	GetAll(ctx context.Context) ([]string, error)
+	GetById(ctx context.Context, id string) (string, error)
+	// GetByIds returns the documents in the order of ids, "" for missing ones.
+	GetByIds(ctx context.Context, ids []string) ([]string, error)
+}
+
+// DocumentService is implemented by services that keep their documents pre-parsed.
+type DocumentService interface {
+	GetDocuments(ctx context.Context) (*service.DocumentSet, error)
 }
 
 type QueryService interface {
-	ApplyQuery(queryParams map[string][]string, data []string) ([]string, error)
+	// Query returns the matching documents and their ids, "" where not known.
+	Query(ctx context.Context, queryParams map[string][]string, docs *service.DocumentSet) ([]string, []string, error)
+	Aggregate(ctx context.Context, queryParams map[string][]string, docs *service.DocumentSet) (*service.Aggregation, error)
+	Facets(ctx context.Context, queryParams map[string][]string, docs *service.DocumentSet) (map[string][]service.FacetValue, error)
+	// Check applies the schema policy to a single document, false means it is not to be sent.
+	Check(raw string) (string, bool)
+	Validate(value interface{}) []schema.Error
+}
+
+// prefixRoute is everything the handlers of one configured prefix need.
+type prefixRoute struct {
+	prefix       conf.Prefix
+	redisService RedisService
+	queryService QueryService
+	// queries deduplicates identical filtered reads, nil unless coalesce_queries is set
+	queries *service.Coalescer
+	// cacheControl is the rendered Cache-Control header, "" when not configured
+	cacheControl string
+	// compressionMinSize is the smallest body worth compressing
+	compressionMinSize int
+	// payloads keeps the unfiltered collection ready to send, cached prefixes only
+	payloads payloadCache
+	// relations can be expanded with _expand, keyed by relation name
+	relations map[string]relation
+	// keyspace is set instead of redisService on prefixes with path parameters
+	keyspace *service.JsonServiceImpl
+	// source reads the prefix uncached, for profiling
+	source *service.JsonServiceImpl
+	// inferred caches the schema inferred from the documents, for prefixes without one
+	inferred inferredSchema
 }
 
-func BuildRouting(e *echo.Echo) {
+// BuildRouting registers the handlers of every prefix and returns their routes by URI.
+func BuildRouting(e *echo.Echo) map[string]*prefixRoute {
+	routes := make(map[string]*prefixRoute, len(config.Prefixes))
 	for _, prefix := range config.Prefixes {
+		routes[prefix.URI] = buildRoute(prefix, e.Logger)
+	}
+	resolveRelations(routes)
+
+	for _, prefix := range config.Prefixes {
+
+		route := routes[prefix.URI]
+		middleware := []echo.MiddlewareFunc{withRequestContext(prefix.RequestTimeout), withServiceErrors}
+
+		e.GET(prefix.URI, route.handleGetAll, middleware...)
+		e.GET(prefix.URI+"/:id", route.handleGetOne, middleware...)
+		// a GET on the POST-only endpoints would be a 405, it is a reserved id instead
+		e.GET(prefix.URI+"/_batch", handleReservedId, middleware...)
+		e.GET(prefix.URI+"/_validate", handleReservedId, middleware...)
+		e.POST(prefix.URI+"/_batch", route.handleBatch, middleware...)
+		e.GET(prefix.URI+"/_aggregate", route.handleAggregate, middleware...)
+		e.GET(prefix.URI+"/_facets", route.handleFacets, middleware...)
+		if prefix.Schema != "" {
+			e.POST(prefix.URI+"/_validate", route.handleValidate, middleware...)
+		}
+		// without the request timeout, a large sample takes a while
+		e.GET("/_admin/prefixes"+prefix.URI+"/profile", route.handleProfile, withServiceErrors)
+
+	}
+
+	return routes
+}
 
-		queryService, redisService := buildServices(prefix, e.Logger)
+// withRequestContext bounds the request context by timeout, when one is set, and
+// turns an expired or cancelled context into the matching HTTP status.
+func withRequestContext(timeout time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+				c.SetRequest(c.Request().WithContext(ctx))
+			}
 
-		e.GET(prefix.URI, func(c echo.Context) error {
-			return handleGetAll(c, redisService, queryService)
-		})
+			err := next(c)
+			if err == nil {
+				return nil
+			}
 
-		e.GET(prefix.URI+"/:id", func(c echo.Context) error {
-			return handleGetOne(c, redisService)
-		})
+			// redis may report the deadline as an i/o timeout, so the context decides
+			switch {
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				return echo.NewHTTPError(http.StatusGatewayTimeout, "request timed out")
+			case errors.Is(ctx.Err(), context.Canceled):
+				// the client is gone, there is nobody left to answer
+				return echo.NewHTTPError(statusClientClosedRequest, "client closed request")
+			}
 
+			return err
+		}
 	}
 }
 
-func handleGetAll(c echo.Context, service RedisService, queryService QueryService) error {
-	all, err := service.GetAll()
+// withServiceErrors makes an unavailable Redis fail fast with 503 instead of a
+// generic 500, reports unusable query parameters as 400 and unreadable stored
+// documents as 502.
+func withServiceErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if errors.Is(err, service.ErrCircuitOpen) || errors.Is(err, service.ErrNoHealthyReplica) {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		}
+		var expressionErr *service.ExpressionError
+		if errors.As(err, &expressionErr) {
+			return echo.NewHTTPError(http.StatusBadRequest, map[string]interface{}{
+				"message":  err.Error(),
+				"position": expressionErr.Position,
+			})
+		}
+		if errors.Is(err, service.ErrInvalidQuery) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, service.ErrInvalidDocument) {
+			return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+		}
+
+		return err
+	}
+}
+
+func writeMetaHeaders(c echo.Context, meta *service.ResponseMeta) {
+	if meta.Generation > 0 {
+		c.Response().Header().Set("X-Cache-Generation", strconv.FormatUint(meta.Generation, 10))
+		c.Response().Header().Set("X-Cache-Built-At", meta.BuiltAt.UTC().Format(time.RFC3339Nano))
+	}
+	if meta.Stale {
+		c.Response().Header().Set("Warning", `110 - "Response is Stale"`)
+		c.Response().Header().Set("X-Cache", "STALE")
+	}
+}
+
+// reservedParams are the query parameters that configure a read, the others filter documents.
+var reservedParams = map[string]bool{
+	paramExpand:          true,
+	paramIds:             true,
+	service.ParamFilter:  true,
+	service.ParamSearch:  true,
+	service.ParamGroupBy: true,
+	service.ParamMetrics: true,
+	service.ParamFields:  true,
+	service.ParamLimit:   true,
+}
+
+// filterParams copies the query parameters of a read taking the reserved ones
+// in accepted. Any other reserved parameter is rejected with 400 instead of
+// filtering on a field of that name.
+func filterParams(c echo.Context, accepted ...string) (url.Values, error) {
+	queryParams := url.Values{}
+	for key, values := range c.QueryParams() {
+		if reservedParams[key] && !slices.Contains(accepted, key) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s is not supported on %s", key, c.Path()))
+		}
+		queryParams[key] = values
+	}
+
+	return queryParams, nil
+}
+
+func (r *prefixRoute) handleGetAll(c echo.Context) error {
+	// a copy, the reserved parameters are taken out before the rest becomes filters
+	queryParams, err := filterParams(c, paramExpand, paramIds, service.ParamFilter, service.ParamSearch)
 	if err != nil {
 		return err
 	}
+	expansions, err := r.parseExpansion(queryParams)
+	if err != nil {
+		return err
+	}
+	redisService, scope, err := r.serviceFor(c)
+	if err != nil {
+		return err
+	}
+	if _, found := queryParams[paramIds]; found {
+		return r.handleGetIds(c, redisService, queryParams, expansions)
+	}
 
-	queryParams := c.QueryParams()
-	if queryParams != nil && len(queryParams) > 0 {
-		all, err = queryService.ApplyQuery(queryParams, all)
-		if err != nil {
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
+	result, err := r.query(ctx, redisService, scope, queryParams)
+	if err != nil {
+		return err
+	}
+	all := result.docs
+	if expansions != nil {
+		if all, err = r.expand(ctx, all, expansions, result.ids); err != nil {
 			return err
 		}
+		// embedded documents come from other prefixes, the snapshot alone no longer identifies the body
+		meta.Digest = 0
+	}
+
+	writeMetaHeaders(c, meta)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+	if meta.Digest != 0 {
+		if len(queryParams) == 0 {
+			payload := r.payloads.get(meta.Digest, all)
+			encoding := r.contentEncoding(c, len(payload.body))
+			if r.writeValidators(c, withEncoding(snapshotETag(meta.Digest, ""), encoding), meta.ModifiedAt) {
+				return c.NoContent(http.StatusNotModified)
+			}
+			return r.writeJSON(c, payload.body, encoding, func() ([]byte, error) {
+				return payload.encode(encoding)
+			})
+		}
+
+		// a cached result is fully determined by the snapshot and the query, so its
+		// validator is known before the body is assembled
+		encoding := r.contentEncoding(c, joinedLength(all))
+		etag := withEncoding(snapshotETag(meta.Digest, service.NormalizeQuery(queryParams)), encoding)
+		if r.writeValidators(c, etag, meta.ModifiedAt) {
+			return c.NoContent(http.StatusNotModified)
+		}
+
+		return r.writeJSON(c, joinJSON(all), encoding, nil)
+	}
+
+	body := joinJSON(all)
+	encoding := r.contentEncoding(c, len(body))
+	if r.writeValidators(c, withEncoding(bodyETag(body), encoding), time.Time{}) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return r.writeJSON(c, body, encoding, nil)
+}
+
+// joinedLength is the length of joinJSON(docs), without joining them.
+func joinedLength(docs []string) int {
+	length := 2 + max(len(docs)-1, 0)
+	for _, doc := range docs {
+		length += len(doc)
 	}
 
-	result := strings.Builder{}
+	return length
+}
+
+func joinJSON(docs []string) []byte {
+	result := bytes.Buffer{}
 	result.WriteString("[")
-	for i, jsonString := range all {
+	for i, jsonString := range docs {
 		if i > 0 {
 			result.WriteString(",")
 		}
@@ -57,31 +282,252 @@ func handleGetAll(c echo.Context, service RedisService, queryService QueryServic
 	}
 	result.WriteString("]")
 
-	return c.JSONBlob(http.StatusOK, []byte(result.String()))
+	return result.Bytes()
 }
 
-func handleGetOne(c echo.Context, service RedisService) error {
+// queryResult is what query returns, shared by coalesced reads.
+type queryResult struct {
+	docs []string
+	ids  []string
+}
+
+// query loads the prefix and applies the query parameters to it. scope tells
+// apart the sub-keyspaces of a prefix with path parameters.
+func (r *prefixRoute) query(ctx context.Context, redisService RedisService, scope string, queryParams map[string][]string) (*queryResult, error) {
+	load := func(ctx context.Context) (interface{}, error) {
+		// documents are checked even unfiltered, for the invalid JSON policy
+		docs, err := documents(ctx, redisService)
+		if err != nil {
+			return nil, err
+		}
+		result, ids, err := r.queryService.Query(ctx, queryParams, docs)
+		if err != nil {
+			return nil, err
+		}
+		return &queryResult{docs: result, ids: ids}, nil
+	}
+
+	if len(queryParams) == 0 || r.queries == nil {
+		result, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return result.(*queryResult), nil
+	}
+
+	result, err := r.queries.Do(ctx, scope+"?"+service.NormalizeQuery(queryParams), load)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*queryResult), nil
+}
+
+// documents returns the whole prefix parsed, straight from the cache when it keeps it that way.
+func documents(ctx context.Context, redisService RedisService) (*service.DocumentSet, error) {
+	if source, ok := redisService.(DocumentService); ok {
+		return source.GetDocuments(ctx)
+	}
+
+	all, err := redisService.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewDocumentSet(all), nil
+}
+
+// handleReservedId answers GET /prefix/_batch and /prefix/_validate. Those ids,
+// like _aggregate and _facets, name endpoints next to /prefix/:id, documents
+// stored under them are read with ?_ids=, as in GET /prefix?_ids=_facets.
+func handleReservedId(c echo.Context) error {
+	id := path.Base(c.Path())
+	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s is reserved for an endpoint, read the document with ?%s=%s", id, paramIds, id))
+}
+
+func (r *prefixRoute) handleGetOne(c echo.Context) error {
+	expansions, err := r.parseExpansion(url.Values{paramExpand: c.QueryParams()[paramExpand]})
+	if err != nil {
+		return err
+	}
+
+	redisService, _, err := r.serviceFor(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, meta := service.WithResponseMeta(c.Request().Context())
 	id := c.Param("id")
-	result, err := service.GetById(id)
+	result, err := redisService.GetById(ctx, id)
 	if err != nil {
 		return err
 	}
+	if result != "" {
+		var keep bool
+		if result, keep = r.queryService.Check(result); !keep {
+			result = ""
+		}
+	}
+	if result != "" && expansions != nil {
+		expandedResult, err := r.expand(ctx, []string{result}, expansions, []string{id})
+		if err != nil {
+			return err
+		}
+		result = expandedResult[0]
+		// embedded documents may have changed after the snapshot did
+		meta.ModifiedAt = time.Time{}
+	}
 
+	writeMetaHeaders(c, meta)
 	if result == "" {
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	return c.JSONBlob(http.StatusOK, []byte(result))
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	body := []byte(result)
+	encoding := r.contentEncoding(c, len(body))
+	if r.writeValidators(c, withEncoding(bodyETag(body), encoding), meta.ModifiedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return r.writeJSON(c, body, encoding, nil)
 }
 
-func buildServices(prefix conf.Prefix, logger echo.Logger) (QueryService, RedisService) {
-	queryService := service.NewQueryService(logger)
-	jsonService := service.NewJsonService(prefix.RedisPrefix, redisPool)
-	var redisService RedisService
-	if prefix.CacheEnabled {
-		redisService = service.NewCacheService(jsonService, prefix.CacheRefreshDuration, prefix.CacheTtl)
+func buildRoute(prefix conf.Prefix, logger echo.Logger) *prefixRoute {
+	route := &prefixRoute{
+		prefix:             prefix,
+		queryService:       service.NewQueryService(logger, querySettings(prefix)),
+		cacheControl:       cacheControlHeader(prefix.CacheControl),
+		compressionMinSize: int(prefix.CompressionMinSize),
+	}
+	if route.compressionMinSize == 0 {
+		route.compressionMinSize = defaultCompressionMinSize
+	}
+
+	jsonService := service.NewJsonService(keyTemplate(prefix), redisBackends[prefix.BackendName()], readFrom(prefix))
+	if len(prefix.PathParams()) > 0 {
+		// bound per request by serviceFor, the whole keyspace is never read
+		route.keyspace = jsonService
+	} else if prefix.CacheEnabled {
+		route.source = jsonService
+		route.redisService = service.NewCacheService(jsonService, cacheSettings(prefix))
 	} else {
-		redisService = jsonService
+		route.source = jsonService
+		coalescer := service.NewCoalescer(prefix.URI, "load", prefix.MicroCacheWindow)
+		route.redisService = service.NewCoalescingService(jsonService, coalescer)
+	}
+
+	if prefix.CoalesceQueries {
+		route.queries = service.NewCoalescer(prefix.URI, "query", prefix.MicroCacheWindow)
+	}
+
+	return route
+}
+
+// keyTemplate returns the key template of the prefix. The configuration was
+// validated before routing is built, so a broken redis_key is a programming error.
+func keyTemplate(prefix conf.Prefix) service.KeyTemplate {
+	if prefix.RedisKey == "" {
+		return service.PrefixTemplate(prefix.RedisPrefix)
+	}
+
+	template, err := service.ParseKeyTemplate(prefix.RedisKey)
+	if err != nil {
+		panic(fmt.Sprintf("prefix %s: %v", prefix.URI, err))
 	}
-	return queryService, redisService
+	return template
+}
+
+// serviceFor returns the service of the request's sub-keyspace and a scope telling
+// it apart from the others, "" for prefixes without path parameters.
+func (r *prefixRoute) serviceFor(c echo.Context) (RedisService, string, error) {
+	if r.keyspace == nil {
+		return r.redisService, "", nil
+	}
+
+	bound, err := r.bind(c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bound, bound.Pattern(), nil
+}
+
+// sourceFor returns the uncached service of the request's sub-keyspace.
+func (r *prefixRoute) sourceFor(c echo.Context) (*service.JsonServiceImpl, error) {
+	if r.keyspace == nil {
+		return r.source, nil
+	}
+
+	return r.bind(c)
+}
+
+func (r *prefixRoute) bind(c echo.Context) (*service.JsonServiceImpl, error) {
+	params := make(map[string]string, len(c.ParamNames()))
+	for _, name := range c.ParamNames() {
+		value, err := url.PathUnescape(c.Param(name))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		params[name] = value
+	}
+	bound, err := r.keyspace.Bind(params)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return bound, nil
+}
+
+func cacheSettings(prefix conf.Prefix) service.CacheSettings {
+	settings := service.CacheSettings{
+		Name:            prefix.URI,
+		RefreshDuration: prefix.CacheRefreshDuration,
+		Ttl:             prefix.CacheTtl,
+		Mode:            service.CacheMode(prefix.CacheMode),
+		NegativeTtl:     prefix.NegativeCacheTtl,
+		MaxBytes:        int64(config.CacheBudget(prefix)),
+		MaxItems:        prefix.CacheMaxItems,
+		Indexes:         prefix.Indexes,
+		SearchFields:    prefix.SearchFields,
+	}
+	if settings.Mode == "" {
+		settings.Mode = service.CacheModeSnapshot
+	}
+	if settings.NegativeTtl == 0 {
+		settings.NegativeTtl = prefix.CacheTtl
+	}
+
+	return settings
+}
+
+func querySettings(prefix conf.Prefix) service.QuerySettings {
+	documentSchema, err := prefix.CompileSchema()
+	if err != nil {
+		panic(fmt.Sprintf("prefix %s: %v", prefix.URI, err))
+	}
+
+	settings := service.QuerySettings{
+		Name:         prefix.URI,
+		SearchFields: prefix.SearchFields,
+		InvalidJson:  service.InvalidJsonPolicy(prefix.InvalidJson),
+		Schema:       documentSchema,
+		SchemaPolicy: service.SchemaPolicy(prefix.SchemaPolicy),
+	}
+	// nothing enforces the schema on writes, so reads flag violations unless told otherwise
+	if settings.SchemaPolicy == "" && documentSchema != nil {
+		settings.SchemaPolicy = service.SchemaPolicyAnnotate
+	}
+	if settings.SchemaPolicy == "" {
+		settings.SchemaPolicy = service.SchemaPolicyPass
+	}
+
+	return settings
+}
+
+// readFrom defaults to the primary, replicas may lag and prefixes opt in to reading them.
+func readFrom(prefix conf.Prefix) service.ReadFrom {
+	if prefix.ReadFrom == "" {
+		return service.ReadFromPrimary
+	}
+
+	return service.ReadFrom(prefix.ReadFrom)
 }
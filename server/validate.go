@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"redis-go-dispatcher/schema"
+)
+
+// validationFailure is the 422 body of /_validate.
+type validationFailure struct {
+	Message string         `json:"message"`
+	Errors  []schema.Error `json:"errors"`
+}
+
+// handleValidate serves POST /prefix/_validate. Writers send a document before
+// storing it and get 204 when it conforms to the prefix's schema, 422 with the
+// violations otherwise.
+func (r *prefixRoute) handleValidate(c echo.Context) error {
+	var document interface{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&document); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "expected a JSON document: "+err.Error())
+	}
+
+	if violations := r.queryService.Validate(document); len(violations) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, validationFailure{
+			Message: "the document does not match the schema of " + r.prefix.URI,
+			Errors:  violations,
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// ParamGroupBy lists the fields to group by, e.g. ?_groupBy=brand,owner.city.
+	ParamGroupBy = "_groupBy"
+	// ParamMetrics lists what to compute per group, e.g. ?_metrics=count,avg(price).
+	// It defaults to count.
+	ParamMetrics = "_metrics"
+)
+
+// metricFunctions take a field, count takes none.
+var metricFunctions = map[string]bool{"sum": true, "avg": true, "min": true, "max": true}
+
+type metric struct {
+	// name is how the metric appears in the result, e.g. avg(price)
+	name      string
+	function  string
+	fieldPath path
+}
+
+// Bucket holds the group values and the metrics of one group.
+type Bucket map[string]interface{}
+
+// Aggregation renders as a single bucket without group fields and as an array
+// of buckets, ordered by group values, with them.
+type Aggregation struct {
+	GroupBy []string
+	Buckets []Bucket
+}
+
+func (a *Aggregation) MarshalJSON() ([]byte, error) {
+	if len(a.GroupBy) == 0 {
+		return json.Marshal(a.Buckets[0])
+	}
+
+	return json.Marshal(a.Buckets)
+}
+
+// Aggregate filters docs by the query parameters and computes the metrics per
+// group. A document holding several values for a group field, e.g. an array,
+// counts in the group of each; one holding none or null falls into the null group.
+// sum and avg only consider numbers, min and max numbers or strings.
+func (s *QueryService) Aggregate(ctx context.Context, queryParams map[string][]string, docs *DocumentSet) (*Aggregation, error) {
+	filterParams := make(map[string][]string, len(queryParams))
+	for key, values := range queryParams {
+		filterParams[key] = values
+	}
+	delete(filterParams, ParamGroupBy)
+	delete(filterParams, ParamMetrics)
+
+	groupBy := splitList(queryParams[ParamGroupBy])
+	metrics, err := parseMetrics(splitList(queryParams[ParamMetrics]))
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range groupBy {
+		for _, m := range metrics {
+			if field == m.name {
+				return nil, fmt.Errorf("%w: %s %s clashes with metric %s", ErrInvalidQuery, ParamGroupBy, field, m.name)
+			}
+		}
+	}
+
+	matching, err := s.matching(ctx, filterParams, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	groupPaths := make([]path, len(groupBy))
+	for i, field := range groupBy {
+		groupPaths[i] = buildPath(field)
+	}
+
+	groups := make(map[string]*group)
+	var order []*group
+	for _, doc := range matching {
+		// kept under InvalidJsonInclude, there is nothing to aggregate in them
+		if doc.Err != nil {
+			continue
+		}
+		for _, key := range groupKeys(groupPaths, doc.Value) {
+			encoded, _ := json.Marshal(key)
+			g, found := groups[string(encoded)]
+			if !found {
+				g = newGroup(key, metrics)
+				groups[string(encoded)] = g
+				order = append(order, g)
+			}
+			g.add(doc.Value)
+		}
+	}
+	if len(groupBy) == 0 && len(order) == 0 {
+		order = append(order, newGroup(nil, metrics))
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareKeys(order[i].key, order[j].key) < 0
+	})
+
+	aggregation := &Aggregation{GroupBy: groupBy, Buckets: make([]Bucket, 0, len(order))}
+	for _, g := range order {
+		bucket := make(Bucket, len(groupBy)+len(metrics))
+		for i, field := range groupBy {
+			bucket[field] = g.key[i]
+		}
+		for i, m := range metrics {
+			bucket[m.name] = g.accumulators[i].result(m.function)
+		}
+		aggregation.Buckets = append(aggregation.Buckets, bucket)
+	}
+
+	return aggregation, nil
+}
+
+// splitList reads comma separated values, repeated parameters add up.
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items
+}
+
+func parseMetrics(names []string) ([]metric, error) {
+	if len(names) == 0 {
+		names = []string{"count"}
+	}
+
+	metrics := make([]metric, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		m := metric{name: strings.ToLower(name), function: strings.ToLower(name)}
+		if open := strings.IndexByte(name, '('); open > 0 && strings.HasSuffix(name, ")") {
+			field := strings.TrimSpace(name[open+1 : len(name)-1])
+			m.function = strings.ToLower(strings.TrimSpace(name[:open]))
+			m.name = m.function + "(" + field + ")"
+			if !metricFunctions[m.function] || field == "" {
+				return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidQuery, name)
+			}
+			m.fieldPath = buildPath(field)
+		} else if m.function != "count" {
+			return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidQuery, name)
+		}
+
+		if !seen[m.name] {
+			seen[m.name] = true
+			metrics = append(metrics, m)
+		}
+	}
+
+	return metrics, nil
+}
+
+// groupKeys returns every combination of group values a document holds.
+func groupKeys(paths []path, doc map[string]interface{}) [][]interface{} {
+	keys := [][]interface{}{{}}
+	for _, p := range paths {
+		values := distinctValues(p.values(doc))
+		if len(values) == 0 {
+			values = []interface{}{nil}
+		}
+
+		combined := make([][]interface{}, 0, len(keys)*len(values))
+		for _, key := range keys {
+			for _, value := range values {
+				combined = append(combined, append(append(make([]interface{}, 0, len(key)+1), key...), value))
+			}
+		}
+		keys = combined
+	}
+
+	return keys
+}
+
+// distinctValues drops repeated values, so a document counts once per group.
+func distinctValues(values []interface{}) []interface{} {
+	distinct := make([]interface{}, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		encoded, _ := json.Marshal(value)
+		if !seen[string(encoded)] {
+			seen[string(encoded)] = true
+			distinct = append(distinct, value)
+		}
+	}
+
+	return distinct
+}
+
+// compareKeys orders group keys value by value: null, booleans, numbers,
+// strings, then anything else by its JSON form.
+func compareKeys(a []interface{}, b []interface{}) int {
+	for i := range a {
+		if order := compareValues(a[i], b[i]); order != 0 {
+			return order
+		}
+	}
+
+	return 0
+}
+
+func compareValues(a interface{}, b interface{}) int {
+	if rankA, rankB := typeRank(a), typeRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		default:
+			return 1
+		}
+	case float64:
+		return compareFloats(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	default:
+		encodedA, _ := json.Marshal(a)
+		encodedB, _ := json.Marshal(b)
+		return strings.Compare(string(encodedA), string(encodedB))
+	}
+}
+
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	default:
+		return 4
+	}
+}
+
+type group struct {
+	key          []interface{}
+	metrics      []metric
+	accumulators []accumulator
+}
+
+func newGroup(key []interface{}, metrics []metric) *group {
+	return &group{key: key, metrics: metrics, accumulators: make([]accumulator, len(metrics))}
+}
+
+func (g *group) add(doc map[string]interface{}) {
+	for i, m := range g.metrics {
+		if m.function == "count" {
+			g.accumulators[i].count++
+			continue
+		}
+		for _, value := range m.fieldPath.values(doc) {
+			g.accumulators[i].add(value)
+		}
+	}
+}
+
+type accumulator struct {
+	count int
+	sum   float64
+	// min and max hold the extremes seen so far, nil before the first value
+	min interface{}
+	max interface{}
+}
+
+func (a *accumulator) add(value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		a.count++
+		a.sum += v
+	case string:
+	default:
+		return
+	}
+
+	if a.min == nil || compareValues(value, a.min) < 0 {
+		a.min = value
+	}
+	if a.max == nil || compareValues(value, a.max) > 0 {
+		a.max = value
+	}
+}
+
+func (a *accumulator) result(function string) interface{} {
+	switch function {
+	case "count":
+		return a.count
+	case "sum":
+		return a.sum
+	case "avg":
+		if a.count == 0 {
+			return nil
+		}
+		return a.sum / float64(a.count)
+	case "min":
+		return a.min
+	default:
+		return a.max
+	}
+}
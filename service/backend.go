@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"redis-go-dispatcher/metrics"
+)
+
+type ReadFrom string
+
+const (
+	ReadFromPrimary       ReadFrom = "primary"
+	ReadFromReplica       ReadFrom = "replica"
+	ReadFromPreferReplica ReadFrom = "prefer_replica"
+)
+
+var ErrNoHealthyReplica = errors.New("no healthy redis replica available")
+
+// RedisBackend is one named Redis deployment: a primary pool plus optional read
+// replicas. All nodes are health checked in the background.
+type RedisBackend struct {
+	name     string
+	primary  *node
+	replicas []*node
+	next     atomic.Uint64
+}
+
+type node struct {
+	name     string
+	pool     *redis.Pool
+	healthy  atomic.Bool
+	breaker  *circuitBreaker
+	commands *metrics.Counter
+	errors   *metrics.Counter
+}
+
+type BackendStatus struct {
+	Name    string       `json:"name"`
+	Healthy bool         `json:"healthy"`
+	Nodes   []NodeStatus `json:"nodes"`
+}
+
+type NodeStatus struct {
+	Name              string `json:"name"`
+	Healthy           bool   `json:"healthy"`
+	CircuitOpen       bool   `json:"circuit_open"`
+	ActiveConnections int    `json:"active_connections"`
+	IdleConnections   int    `json:"idle_connections"`
+}
+
+func NewRedisBackend(name string, primary *redis.Pool, replicaPools []*redis.Pool, breaker BreakerSettings) *RedisBackend {
+	backend := &RedisBackend{name: name, primary: newNode(name, "primary", primary, breaker)}
+	for i, pool := range replicaPools {
+		backend.replicas = append(backend.replicas, newNode(name, fmt.Sprintf("replica-%d", i), pool, breaker))
+	}
+
+	return backend
+}
+
+func newNode(backend string, name string, pool *redis.Pool, breaker BreakerSettings) *node {
+	labels := metrics.Labels{"backend": backend, "node": name}
+	n := &node{
+		name:     name,
+		pool:     pool,
+		breaker:  newCircuitBreaker(breaker),
+		commands: metrics.NewCounter("redis_commands_total", labels),
+		errors:   metrics.NewCounter("redis_command_errors_total", labels),
+	}
+
+	metrics.NewGaugeFunc("redis_node_up", labels, func() float64 {
+		if n.healthy.Load() {
+			return 1
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("redis_circuit_open", labels, func() float64 {
+		if n.breaker.isOpen() {
+			return 1
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("redis_pool_active_connections", labels, func() float64 {
+		return float64(pool.Stats().ActiveCount)
+	})
+	metrics.NewGaugeFunc("redis_pool_idle_connections", labels, func() float64 {
+		return float64(pool.Stats().IdleCount)
+	})
+	metrics.NewGaugeFunc("redis_pool_wait_count", labels, func() float64 {
+		return float64(pool.Stats().WaitCount)
+	})
+
+	return n
+}
+
+func (b *RedisBackend) Name() string {
+	return b.name
+}
+
+func (b *RedisBackend) nodes() []*node {
+	return append([]*node{b.primary}, b.replicas...)
+}
+
+// StartHealthChecks runs the first check synchronously, so that replicas are usable
+// right after startup, and then keeps checking every interval.
+func (b *RedisBackend) StartHealthChecks(interval time.Duration) {
+	b.checkNodes()
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			b.checkNodes()
+		}
+	}()
+}
+
+func (b *RedisBackend) checkNodes() {
+	for _, n := range b.nodes() {
+		n.healthy.Store(ping(n.pool) == nil)
+	}
+}
+
+func ping(pool *redis.Pool) error {
+	conn := pool.Get()
+	defer func(conn redis.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+// Status reports the last health check result of every node. The backend is
+// healthy when its primary is.
+func (b *RedisBackend) Status() BackendStatus {
+	status := BackendStatus{Name: b.name, Healthy: b.primary.healthy.Load()}
+	for _, n := range b.nodes() {
+		stats := n.pool.Stats()
+		status.Nodes = append(status.Nodes, NodeStatus{
+			Name:              n.name,
+			Healthy:           n.healthy.Load(),
+			CircuitOpen:       n.breaker.isOpen(),
+			ActiveConnections: stats.ActiveCount,
+			IdleConnections:   stats.IdleCount,
+		})
+	}
+
+	return status
+}
+
+// ReadConn borrows a connection for reads. Healthy replicas are used round-robin.
+func (b *RedisBackend) ReadConn(ctx context.Context, readFrom ReadFrom) (redis.Conn, error) {
+	n, err := b.readNode(readFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := n.pool.GetContext(ctx)
+	if err != nil {
+		n.errors.Inc()
+		n.breaker.record(ctx, err)
+		return nil, err
+	}
+
+	return &instrumentedConn{Conn: conn, node: n}, nil
+}
+
+// CircuitOpen reports whether a read with readFrom would currently be refused
+// because the circuit of every node it may use is open.
+func (b *RedisBackend) CircuitOpen(readFrom ReadFrom) bool {
+	if readFrom != ReadFromPrimary {
+		for _, n := range b.replicas {
+			if n.healthy.Load() && !n.breaker.isOpen() {
+				return false
+			}
+		}
+
+		if readFrom == ReadFromReplica {
+			return true
+		}
+	}
+
+	return b.primary.breaker.isOpen()
+}
+
+func (b *RedisBackend) readNode(readFrom ReadFrom) (*node, error) {
+	if readFrom != ReadFromPrimary {
+		if n := b.availableReplica(); n != nil {
+			return n, nil
+		}
+
+		if readFrom == ReadFromReplica {
+			return nil, ErrNoHealthyReplica
+		}
+	}
+
+	if !b.primary.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	return b.primary, nil
+}
+
+func (b *RedisBackend) availableReplica() *node {
+	count := uint64(len(b.replicas))
+	if count == 0 {
+		return nil
+	}
+
+	start := b.next.Add(1)
+	for i := uint64(0); i < count; i++ {
+		n := b.replicas[(start+i)%count]
+		if n.healthy.Load() && n.breaker.allow() {
+			return n
+		}
+	}
+
+	return nil
+}
+
+type instrumentedConn struct {
+	redis.Conn
+	node *node
+}
+
+func (c *instrumentedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return c.DoContext(context.Background(), commandName, args...)
+}
+
+// ReceiveContext completes redis.ConnWithContext together with DoContext.
+func (c *instrumentedConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redis.ReceiveContext(c.Conn, ctx)
+}
+
+func (c *instrumentedConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	c.node.commands.Inc()
+	reply, err := redis.DoContext(c.Conn, ctx, commandName, args...)
+	if err != nil {
+		c.node.errors.Inc()
+	}
+	c.node.breaker.record(ctx, err)
+
+	return reply, err
+}
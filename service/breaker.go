@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var ErrCircuitOpen = errors.New("redis circuit breaker is open")
+
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive connection failures that opens
+	// the circuit. Zero disables the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before a single probe is let through.
+	OpenTimeout time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	settings BreakerSettings
+	mutex    sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(settings BreakerSettings) *circuitBreaker {
+	return &circuitBreaker{settings: settings}
+}
+
+// allow reports whether a call may go to Redis. Once the open timeout has passed
+// exactly one probe is allowed, everyone else keeps failing fast until it reports back.
+func (b *circuitBreaker) allow() bool {
+	if b.settings.FailureThreshold == 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.settings.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	default:
+		return false
+	}
+}
+
+// isOpen reports whether the circuit is not known to be healthy, without claiming the probe.
+func (b *circuitBreaker) isOpen() bool {
+	if b.settings.FailureThreshold == 0 {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state != breakerClosed
+}
+
+// record feeds the outcome of a call back into the breaker. Only failures of the
+// connection itself count, Redis error replies and cancelled requests do not.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b.settings.FailureThreshold == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err != nil && !isConnectionError(ctx, err) {
+		if b.state == breakerHalfOpen {
+			// the probe proved nothing, let the next caller try again
+			b.state = breakerOpen
+			b.openedAt = time.Now().Add(-b.settings.OpenTimeout)
+		}
+		return
+	}
+
+	if err == nil {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func isConnectionError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var redisError redis.Error
+	return !errors.As(err, &redisError) && !errors.Is(err, redis.ErrNil)
+}
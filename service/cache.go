@@ -1,54 +1,211 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
+	"golang.org/x/sync/singleflight"
+	"redis-go-dispatcher/metrics"
 )
 
 type RedisService interface {
-	GetByKey(id string) (string, error)
-	GetAll() ([]string, error)
-	GetAllKeys() ([]string, error)
-	GetPrefix() string
-	GetById(id string) (string, error)
+	GetByKey(ctx context.Context, id string) (string, error)
+	GetByKeys(ctx context.Context, keys []string) ([]string, error)
+	GetByIds(ctx context.Context, ids []string) ([]string, error)
+	GetAll(ctx context.Context) ([]string, error)
+	// GetDocuments returns every document parsed and with its id.
+	GetDocuments(ctx context.Context) (*DocumentSet, error)
+	GetAllKeys(ctx context.Context) ([]string, error)
+	// Key returns the Redis key of the document id.
+	Key(id string) string
+	// Id returns the id of the document stored under key.
+	Id(key string) (string, bool)
+	GetById(ctx context.Context, id string) (string, error)
+	CircuitOpen() bool
+}
+
+type CacheMode string
+
+const (
+	// CacheModeSnapshot serves only what the last warm-up saw. Misses are misses.
+	CacheModeSnapshot CacheMode = "snapshot"
+	// CacheModeReadThrough loads misses and expired data from Redis synchronously.
+	CacheModeReadThrough CacheMode = "read_through"
+	// CacheModeStaleWhileRevalidate is read-through for misses, but serves expired
+	// data right away and refreshes it in the background.
+	CacheModeStaleWhileRevalidate CacheMode = "stale_while_revalidate"
+)
+
+var ErrCacheBudgetExceeded = errors.New("cache budget exceeded")
+
+type CacheSettings struct {
+	// Name labels the cache metrics, usually the prefix URI.
+	Name            string
+	RefreshDuration time.Duration
+	Ttl             time.Duration
+	Mode            CacheMode
+	// NegativeTtl is how long a key that is missing in Redis is remembered as missing.
+	NegativeTtl time.Duration
+	// MaxBytes bounds the memory of the prefix, zero means unlimited. In the
+	// read-through modes a quarter of it goes to documents loaded on demand.
+	// A snapshot is charged for its raw documents, their parsed values and its
+	// indexes. The previous snapshot stays in memory until the next one is
+	// swapped in, so a refresh briefly holds up to twice the snapshot's share.
+	MaxBytes int64
+	// MaxItems bounds the number of documents in a snapshot, zero means unlimited.
+	MaxItems int64
+	// Indexes are the field paths indexed in every snapshot.
+	Indexes []string
+	// SearchFields get an inverted index in every snapshot, for _q.
+	SearchFields []string
+}
+
+const (
+	// defaultEntriesMaxCost bounds documents loaded on demand when the prefix has no budget
+	defaultEntriesMaxCost = 64 << 20
+	entriesBudgetShare    = 4
+	// assumedDocumentSize sizes the ristretto counters when only a byte budget is known
+	assumedDocumentSize = 1 << 10
+)
+
+type cacheMetrics struct {
+	snapshotRejections *metrics.Counter
+	evictions          *metrics.Counter
+	rejections         *metrics.Counter
 }
 
 type RedisCachedService struct {
-	cache        *ristretto.Cache
-	service      RedisService
-	cacheTtl     time.Duration
-	cacheKeysKey string
-}
-
-func NewCacheService(
-	readService RedisService,
-	cacheRefreshDuration time.Duration,
-	cacheTtl time.Duration,
-) *RedisCachedService {
-	cache, err := ristretto.NewCache(&ristretto.Config{
-		NumCounters: 1e7,     // number of keys to track frequency of (10M).
-		MaxCost:     1 << 30, // maximum cost of cache (1GB).
-		BufferItems: 64,      // number of keys per Get buffer.
-	})
-	if err != nil {
-		panic(err)
-	}
+	service    RedisService
+	settings   CacheSettings
+	snapshot   atomic.Pointer[cacheSnapshot]
+	generation atomic.Uint64
+	// entries holds documents loaded on demand by the read-through modes
+	entries *ristretto.Cache
+	loads   singleflight.Group
+	// refreshing holds the keys of the background refreshes in progress
+	refreshing sync.Map
+	metrics    cacheMetrics
+}
 
+// cacheSnapshot is an immutable copy of a whole prefix. A warm-up builds a new one
+// off to the side and swaps it in, so a request always sees a single generation.
+type cacheSnapshot struct {
+	generation uint64
+	builtAt    time.Time
+	keys       []string
+	data       map[string]string
+	bytes      int64
+	// digest fingerprints keys and data, modifiedAt is when the current digest first appeared
+	digest     uint64
+	modifiedAt time.Time
+	// documents holds data pre-parsed in key order, with the configured indexes
+	documents *DocumentSet
+}
+
+// cacheEntry is a single document loaded on demand. found is false for keys
+// that do not exist in Redis.
+type cacheEntry struct {
+	data      string
+	found     bool
+	expiresAt time.Time
+}
+
+const snapshotLoadKey = "\x00snapshot"
+
+// snapshotChunkSize is how many keys a warm-up reads with one MGET.
+const snapshotChunkSize = 500
+
+// loadTimeout bounds a load shared by several callers, which runs on its own
+// context so that no single caller going away fails the others.
+const loadTimeout = 30 * time.Second
+
+func NewCacheService(readService RedisService, settings CacheSettings) *RedisCachedService {
+	labels := metrics.Labels{"prefix": settings.Name}
 	c := &RedisCachedService{
-		cache:        cache,
-		service:      readService,
-		cacheTtl:     cacheTtl,
-		cacheKeysKey: "REDIS_GO_DISPATCHER_CACHE_KEYS",
+		service:  readService,
+		settings: settings,
+		metrics: cacheMetrics{
+			snapshotRejections: metrics.NewCounter("cache_snapshot_rejections_total", labels),
+			evictions:          metrics.NewCounter("cache_evictions_total", labels),
+			rejections:         metrics.NewCounter("cache_admission_rejections_total", labels),
+		},
 	}
 
-	ticker := time.NewTicker(cacheRefreshDuration)
+	metrics.NewGaugeFunc("cache_snapshot_bytes", labels, func() float64 {
+		if snapshot := c.snapshot.Load(); snapshot != nil {
+			return float64(snapshot.bytes)
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("cache_snapshot_items", labels, func() float64 {
+		if snapshot := c.snapshot.Load(); snapshot != nil {
+			return float64(len(snapshot.keys))
+		}
+		return 0
+	})
+
+	if settings.Mode != CacheModeSnapshot {
+		c.entries = c.newEntriesCache()
+		metrics.NewGaugeFunc("cache_entries_bytes", labels, func() float64 {
+			return float64(c.entries.Metrics.CostAdded() - c.entries.Metrics.CostEvicted())
+		})
+	}
+
+	ticker := time.NewTicker(settings.RefreshDuration)
 	go c.warmUpCacheJob(ticker)
 
 	return c
 }
 
+func (c *RedisCachedService) newEntriesCache() *ristretto.Cache {
+	maxCost := int64(defaultEntriesMaxCost)
+	if c.settings.MaxBytes > 0 {
+		maxCost = c.settings.MaxBytes / entriesBudgetShare
+	}
+
+	// ristretto wants about ten counters per item it may hold
+	numCounters := maxCost / assumedDocumentSize * 10
+	if c.settings.MaxItems > 0 {
+		numCounters = c.settings.MaxItems * 10
+	}
+	numCounters = max(numCounters, 1000)
+
+	entries, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: numCounters,
+		MaxCost:     maxCost,
+		BufferItems: 64, // number of keys per Get buffer.
+		Metrics:     true,
+		OnEvict: func(*ristretto.Item) {
+			c.metrics.evictions.Inc()
+		},
+		OnReject: func(*ristretto.Item) {
+			c.metrics.rejections.Inc()
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return entries
+}
+
+// snapshotMaxBytes is what is left of the budget once on-demand documents got their share.
+func (c *RedisCachedService) snapshotMaxBytes() int64 {
+	if c.settings.MaxBytes == 0 || c.entries == nil {
+		return c.settings.MaxBytes
+	}
+
+	return c.settings.MaxBytes - c.settings.MaxBytes/entriesBudgetShare
+}
+
 func (c *RedisCachedService) warmUpCacheJob(ticker *time.Ticker) {
 	for {
 		select {
@@ -59,52 +216,406 @@ func (c *RedisCachedService) warmUpCacheJob(ticker *time.Ticker) {
 }
 
 func (c *RedisCachedService) warmUpCache() {
-	keys, err := c.service.GetAllKeys()
-	if err != nil {
+	if _, err := c.loadSnapshot(context.Background()); err != nil {
+		// the previous snapshot stays in place until it expires
 		fmt.Println(err)
-		return
+	}
+}
+
+// loadSnapshot builds and swaps in a new snapshot. Concurrent callers share one build.
+func (c *RedisCachedService) loadSnapshot(ctx context.Context) (*cacheSnapshot, error) {
+	result, err := c.sharedLoad(ctx, snapshotLoadKey, func(ctx context.Context) (interface{}, error) {
+		snapshot, err := c.buildSnapshot(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.snapshot.Store(snapshot)
+		return snapshot, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*cacheSnapshot), nil
+}
+
+// sharedLoad runs load once for all concurrent callers with the same key, on a
+// context of its own bounded by loadTimeout. Each caller stops waiting when its
+// own context is done, the load goes on and its result is still cached.
+func (c *RedisCachedService) sharedLoad(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	results := c.loads.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		return load(loadCtx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case shared := <-results:
+		return shared.Val, shared.Err
+	}
+}
+
+func (c *RedisCachedService) buildSnapshot(ctx context.Context) (*cacheSnapshot, error) {
+	keys, err := c.service.GetAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	if c.settings.MaxItems > 0 && int64(len(keys)) > c.settings.MaxItems {
+		c.metrics.snapshotRejections.Inc()
+		return nil, fmt.Errorf("%w: %s has %d keys, cache_max_items is %d", ErrCacheBudgetExceeded, c.settings.Name, len(keys), c.settings.MaxItems)
 	}
 
-	for _, key := range keys {
-		data, err := c.service.GetByKey(key)
+	maxBytes := c.snapshotMaxBytes()
+	snapshot := &cacheSnapshot{keys: make([]string, 0, len(keys)), data: make(map[string]string, len(keys))}
+	for start := 0; start < len(keys); start += snapshotChunkSize {
+		chunk := keys[start:min(start+snapshotChunkSize, len(keys))]
+		values, err := c.service.GetByKeys(ctx, chunk)
 		if err != nil {
+			return nil, err
+		}
+
+		for n, key := range chunk {
+			// the key was deleted between KEYS and MGET
+			if values[n] == "" {
+				continue
+			}
+
+			snapshot.keys = append(snapshot.keys, key)
+			snapshot.data[key] = values[n]
+			snapshot.bytes += documentCost(key, values[n])
+		}
+		// checked per chunk, so an oversized prefix stops loading early
+		if maxBytes > 0 && snapshot.bytes > maxBytes {
+			c.metrics.snapshotRejections.Inc()
+			return nil, fmt.Errorf("%w: %s needs more than %d bytes", ErrCacheBudgetExceeded, c.settings.Name, maxBytes)
+		}
+	}
+
+	docs := make([]Document, 0, len(snapshot.keys))
+	for _, key := range snapshot.keys {
+		doc := ParseDocument(snapshot.data[key])
+		doc.Id, _ = c.service.Id(key)
+		docs = append(docs, doc)
+	}
+	snapshot.documents = &DocumentSet{
+		Docs:    docs,
+		indexes: buildIndexes(c.settings.Indexes, docs),
+		search:  buildSearchIndex(parseSearchFields(c.settings.SearchFields), docs),
+	}
+	snapshot.bytes += snapshot.documents.cost()
+	if maxBytes > 0 && snapshot.bytes > maxBytes {
+		c.metrics.snapshotRejections.Inc()
+		return nil, fmt.Errorf("%w: %s needs %d bytes once parsed and indexed, the budget is %d", ErrCacheBudgetExceeded, c.settings.Name, snapshot.bytes, maxBytes)
+	}
+
+	snapshot.digest = snapshotDigest(snapshot)
+	snapshot.generation = c.generation.Add(1)
+	snapshot.builtAt = time.Now()
+	snapshot.modifiedAt = snapshot.builtAt
+	if previous := c.snapshot.Load(); previous != nil && previous.digest == snapshot.digest {
+		snapshot.modifiedAt = previous.modifiedAt
+	}
+	return snapshot, nil
+}
+
+func snapshotDigest(snapshot *cacheSnapshot) uint64 {
+	hash := fnv.New64a()
+	for _, key := range snapshot.keys {
+		_, _ = hash.Write([]byte(key))
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(snapshot.data[key]))
+		_, _ = hash.Write([]byte{0})
+	}
+
+	return hash.Sum64()
+}
+
+// refreshInBackground starts a load unless one for the same key is already running.
+func (c *RedisCachedService) refreshInBackground(key string, load func(ctx context.Context) error) {
+	if _, running := c.refreshing.LoadOrStore(key, true); running {
+		return
+	}
+
+	go func() {
+		defer c.refreshing.Delete(key)
+		if err := load(context.Background()); err != nil {
 			fmt.Println(err)
-			continue
 		}
+	}()
+}
+
+// usableSnapshot returns the snapshot if it may be served. An expired snapshot is
+// served as stale while the Redis circuit is open, and in stale-while-revalidate
+// mode, where it also triggers a background rebuild.
+func (c *RedisCachedService) usableSnapshot() (*cacheSnapshot, bool) {
+	snapshot := c.snapshot.Load()
+
+	switch {
+	case snapshot == nil:
+		return nil, false
+	case time.Since(snapshot.builtAt) <= c.settings.Ttl:
+		return snapshot, false
+	case c.service.CircuitOpen():
+		return snapshot, true
+	case c.settings.Mode == CacheModeStaleWhileRevalidate:
+		c.refreshInBackground(snapshotLoadKey, func(ctx context.Context) error {
+			_, err := c.loadSnapshot(ctx)
+			return err
+		})
+		return snapshot, true
+	default:
+		return nil, false
+	}
+}
 
-		c.cache.SetWithTTL(key, data, 0, c.cacheTtl)
+// currentSnapshot returns the snapshot a request should be served from. Without a
+// usable snapshot the read-through modes build one synchronously.
+func (c *RedisCachedService) currentSnapshot(ctx context.Context) (*cacheSnapshot, error) {
+	snapshot, stale := c.usableSnapshot()
+	if snapshot == nil && c.settings.Mode != CacheModeSnapshot {
+		var err error
+		if snapshot, err = c.loadSnapshot(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	c.cache.SetWithTTL(c.cacheKeysKey, keys, 0, c.cacheTtl)
+	if snapshot != nil {
+		servedFrom(ctx, snapshot, stale)
+	}
+	return snapshot, nil
+}
+
+func servedFrom(ctx context.Context, snapshot *cacheSnapshot, stale bool) {
+	meta := responseMeta(ctx)
+	meta.Stale = meta.Stale || stale
+	meta.Generation = snapshot.generation
+	meta.BuiltAt = snapshot.builtAt
+	meta.Digest = snapshot.digest
+	meta.ModifiedAt = snapshot.modifiedAt
+}
+
+func (c *RedisCachedService) CircuitOpen() bool {
+	return c.service.CircuitOpen()
 }
 
-func (c *RedisCachedService) GetById(id string) (string, error) {
-	key := c.service.GetPrefix() + id
-	result, found := c.cache.Get(key)
-	if !found {
+func (c *RedisCachedService) GetById(ctx context.Context, id string) (string, error) {
+	key := c.service.Key(id)
+
+	snapshot, stale := c.usableSnapshot()
+	if snapshot != nil {
+		data, found := snapshot.data[key]
+		if found || c.settings.Mode == CacheModeSnapshot {
+			servedFrom(ctx, snapshot, stale)
+			return data, nil
+		}
+	}
+
+	if c.settings.Mode == CacheModeSnapshot {
 		return "", nil
 	}
 
-	return result.(string), nil
+	// keys added after the last warm-up, or any key without a usable snapshot, are read through
+	return c.getEntry(ctx, key)
 }
 
-func (c *RedisCachedService) GetAll() ([]string, error) {
-	keys, found := c.cache.Get(c.cacheKeysKey)
-	if !found {
-		// we don't have keys in cache, so we return empty result
-		return make([]string, 0), nil
+func (c *RedisCachedService) getEntry(ctx context.Context, key string) (string, error) {
+	value, found := c.entries.Get(key)
+	if found {
+		entry := value.(*cacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			return entry.data, nil
+		}
+
+		if c.settings.Mode == CacheModeStaleWhileRevalidate || c.service.CircuitOpen() {
+			responseMeta(ctx).Stale = true
+			c.refreshInBackground(key, func(ctx context.Context) error {
+				_, err := c.loadEntry(ctx, key)
+				return err
+			})
+			return entry.data, nil
+		}
+	}
+
+	entry, err := c.loadEntry(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.data, nil
+}
+
+// loadEntry reads one key from Redis and caches the result, including "not found".
+func (c *RedisCachedService) loadEntry(ctx context.Context, key string) (*cacheEntry, error) {
+	result, err := c.sharedLoad(ctx, key, func(ctx context.Context) (interface{}, error) {
+		data, err := c.service.GetByKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.storeEntry(key, data), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*cacheEntry), nil
+}
+
+func (c *RedisCachedService) storeEntry(key string, data string) *cacheEntry {
+	entry := &cacheEntry{data: data, found: data != "", expiresAt: time.Now().Add(c.settings.Ttl)}
+	if !entry.found {
+		entry.expiresAt = time.Now().Add(c.settings.NegativeTtl)
+	}
+	c.entries.Set(key, entry, documentCost(key, data))
+
+	return entry
+}
+
+// GetByIds answers from the snapshot where it can. In the read-through modes the
+// rest comes from cached entries and a single MGET for whatever is left.
+func (c *RedisCachedService) GetByIds(ctx context.Context, ids []string) ([]string, error) {
+	result := make([]string, len(ids))
+
+	snapshot, stale := c.usableSnapshot()
+	if snapshot != nil {
+		servedFrom(ctx, snapshot, stale)
+	}
+
+	var misses []int
+	for i, id := range ids {
+		if snapshot != nil {
+			if data, found := snapshot.data[c.service.Key(id)]; found {
+				result[i] = data
+				continue
+			}
+		}
+		if c.settings.Mode != CacheModeSnapshot {
+			misses = append(misses, i)
+		}
 	}
 
-	keysSlice := keys.([]string)
-	result := make([]string, 0, len(keysSlice))
-	for _, key := range keysSlice {
-		value, found := c.cache.Get(key)
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	return result, c.getEntries(ctx, ids, misses, result)
+}
+
+// getEntries fills result at the positions in misses, following the rules of getEntry.
+func (c *RedisCachedService) getEntries(ctx context.Context, ids []string, misses []int, result []string) error {
+	var load, revalidate []string
+	var loadPositions []int
+	for _, i := range misses {
+		key := c.service.Key(ids[i])
+		value, found := c.entries.Get(key)
 		if !found {
+			load, loadPositions = append(load, key), append(loadPositions, i)
 			continue
 		}
 
-		result = append(result, value.(string))
+		entry := value.(*cacheEntry)
+		switch {
+		case time.Now().Before(entry.expiresAt):
+			result[i] = entry.data
+		case c.settings.Mode == CacheModeStaleWhileRevalidate || c.service.CircuitOpen():
+			responseMeta(ctx).Stale = true
+			result[i] = entry.data
+			revalidate = append(revalidate, key)
+		default:
+			load, loadPositions = append(load, key), append(loadPositions, i)
+		}
+	}
+
+	if len(revalidate) > 0 {
+		// the refresh is the same whatever order the ids came in
+		sort.Strings(revalidate)
+		c.refreshInBackground(entriesLoadKey(revalidate), func(ctx context.Context) error {
+			_, err := c.loadEntries(ctx, revalidate)
+			return err
+		})
+	}
+
+	loaded, err := c.loadEntries(ctx, load)
+	if err != nil {
+		return err
+	}
+	for n, i := range loadPositions {
+		result[i] = loaded[n]
+	}
+
+	return nil
+}
+
+// loadEntries reads keys with one MGET and caches every result, including "not found".
+// Concurrent loads of the same keys share one MGET.
+func (c *RedisCachedService) loadEntries(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	result, err := c.sharedLoad(ctx, entriesLoadKey(keys), func(ctx context.Context) (interface{}, error) {
+		data, err := c.service.GetByKeys(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		for n, key := range keys {
+			c.storeEntry(key, data[n])
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+// entriesLoadKey names a load of several keys, apart from the single key loads.
+func entriesLoadKey(keys []string) string {
+	return "\x00entries\x00" + strings.Join(keys, "\x00")
+}
+
+func (c *RedisCachedService) GetAll(ctx context.Context) ([]string, error) {
+	snapshot, err := c.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot == nil {
+		// we don't have a snapshot yet, so we return empty result
+		return make([]string, 0), nil
+	}
+
+	result := make([]string, 0, len(snapshot.keys))
+	for _, key := range snapshot.keys {
+		result = append(result, snapshot.data[key])
 	}
 
 	return result, nil
 }
+
+// documentCost is the memory a cached document is accounted with.
+func documentCost(key string, data string) int64 {
+	return int64(len(key) + len(data))
+}
+
+// GetDocuments returns the prefix pre-parsed and indexed, ready for QueryService.Query.
+func (c *RedisCachedService) GetDocuments(ctx context.Context) (*DocumentSet, error) {
+	snapshot, err := c.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot == nil {
+		return &DocumentSet{}, nil
+	}
+
+	return snapshot.documents, nil
+}
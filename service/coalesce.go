@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-go-dispatcher/metrics"
+)
+
+// Coalescer shares one load between all callers asking for the same key at the
+// same time and, with a window, keeps the result around for that long afterwards.
+type Coalescer struct {
+	window    time.Duration
+	mutex     sync.Mutex
+	flights   map[string]*flight
+	results   map[string]coalescedResult
+	coalesced *metrics.Counter
+	hits      *metrics.Counter
+}
+
+// flight is a load in progress. It is cancelled once no caller waits for it any more.
+type flight struct {
+	done    chan struct{}
+	result  coalescedResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+type coalescedResult struct {
+	value interface{}
+	// meta is what the load reported, every caller gets a copy
+	meta      ResponseMeta
+	expiresAt time.Time
+}
+
+// sweepThreshold bounds how many results pile up before expired ones are dropped.
+const sweepThreshold = 1024
+
+// NewCoalescer labels its metrics with the prefix and kind, e.g. "load" or "query".
+func NewCoalescer(prefix string, kind string, window time.Duration) *Coalescer {
+	labels := metrics.Labels{"prefix": prefix, "kind": kind}
+	return &Coalescer{
+		window:    window,
+		flights:   make(map[string]*flight),
+		results:   make(map[string]coalescedResult),
+		coalesced: metrics.NewCounter("coalesced_loads_total", labels),
+		hits:      metrics.NewCounter("micro_cache_hits_total", labels),
+	}
+}
+
+// Do runs load once for all concurrent callers with the same key. The load runs
+// detached from any single caller, so one client going away does not fail the
+// others; it is cancelled when the last caller stops waiting.
+func (c *Coalescer) Do(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if result, found := c.cached(key); found {
+		c.hits.Inc()
+		*responseMeta(ctx) = result.meta
+		return result.value, nil
+	}
+
+	f := c.join(ctx, key, load)
+
+	select {
+	case <-ctx.Done():
+		c.leave(key, f)
+		return nil, ctx.Err()
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		*responseMeta(ctx) = f.result.meta
+		return f.result.value, nil
+	}
+}
+
+// join waits for the flight of key, starting it when there is none.
+func (c *Coalescer) join(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) *flight {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if f, found := c.flights[key]; found {
+		f.waiters++
+		c.coalesced.Inc()
+		return f
+	}
+
+	loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	c.flights[key] = f
+
+	go func() {
+		defer cancel()
+		metaCtx, meta := WithResponseMeta(loadCtx)
+		value, err := load(metaCtx)
+
+		c.mutex.Lock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		if err == nil {
+			f.result = coalescedResult{value: value, meta: *meta}
+			if c.window > 0 {
+				c.store(key, f.result)
+			}
+		}
+		f.err = err
+		c.mutex.Unlock()
+		close(f.done)
+	}()
+
+	return f
+}
+
+// leave stops waiting for f and cancels it when nobody else does. Later callers start a new load.
+func (c *Coalescer) leave(key string, f *flight) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+
+	f.cancel()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+}
+
+func (c *Coalescer) cached(key string) (coalescedResult, bool) {
+	if c.window == 0 {
+		return coalescedResult{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result, found := c.results[key]
+	if !found || time.Now().After(result.expiresAt) {
+		return coalescedResult{}, false
+	}
+
+	return result, true
+}
+
+// store keeps result for the window, the caller holds the mutex.
+func (c *Coalescer) store(key string, result coalescedResult) {
+	now := time.Now()
+	if len(c.results) >= sweepThreshold {
+		for k, result := range c.results {
+			if now.After(result.expiresAt) {
+				delete(c.results, k)
+			}
+		}
+	}
+
+	result.expiresAt = now.Add(c.window)
+	c.results[key] = result
+}
+
+// CoalescingService deduplicates concurrent identical reads of an uncached prefix.
+type CoalescingService struct {
+	service   RedisService
+	coalescer *Coalescer
+}
+
+func NewCoalescingService(service RedisService, coalescer *Coalescer) *CoalescingService {
+	return &CoalescingService{service: service, coalescer: coalescer}
+}
+
+func (s *CoalescingService) GetAll(ctx context.Context) ([]string, error) {
+	result, err := s.coalescer.Do(ctx, "all", func(ctx context.Context) (interface{}, error) {
+		return s.service.GetAll(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+// GetDocuments shares one parsed read of the whole keyspace, see JsonServiceImpl.GetDocuments.
+func (s *CoalescingService) GetDocuments(ctx context.Context) (*DocumentSet, error) {
+	result, err := s.coalescer.Do(ctx, "documents", func(ctx context.Context) (interface{}, error) {
+		return s.service.GetDocuments(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*DocumentSet), nil
+}
+
+func (s *CoalescingService) GetById(ctx context.Context, id string) (string, error) {
+	result, err := s.coalescer.Do(ctx, "id:"+id, func(ctx context.Context) (interface{}, error) {
+		return s.service.GetById(ctx, id)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result.(string), nil
+}
+
+func (s *CoalescingService) GetByIds(ctx context.Context, ids []string) ([]string, error) {
+	result, err := s.coalescer.Do(ctx, "ids:"+strings.Join(ids, "\x00"), func(ctx context.Context) (interface{}, error) {
+		return s.service.GetByIds(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]string), nil
+}
+
+func (s *CoalescingService) CircuitOpen() bool {
+	return s.service.CircuitOpen()
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"redis-go-dispatcher/schema"
+)
+
+// Document is a stored JSON document, parsed once and kept next to its raw form.
+type Document struct {
+	// Id is the id the document is stored under, "" when not known
+	Id    string
+	Raw   string
+	Value map[string]interface{}
+	// Err tells why Raw is not a JSON object, Value is nil then
+	Err error
+	// violations of the prefix's schema, set by DocumentSet.validate
+	violations []schema.Error
+}
+
+func ParseDocument(raw string) Document {
+	value := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return Document{Raw: raw, Err: err}
+	}
+
+	return Document{Raw: raw, Value: value}
+}
+
+// DocumentSet is a collection of documents in key order. Sets taken from a cache
+// snapshot also carry the snapshot's indexes.
+type DocumentSet struct {
+	Docs    []Document
+	indexes map[string]*fieldIndex
+	search  *searchIndex
+	// validated guards the violations, a snapshot's set is checked once for all requests
+	validated sync.Once
+	// invalid holds the positions of the documents that are not JSON objects, found once
+	invalid        []int
+	invalidScanned sync.Once
+}
+
+func NewDocumentSet(data []string) *DocumentSet {
+	docs := make([]Document, 0, len(data))
+	for _, raw := range data {
+		docs = append(docs, ParseDocument(raw))
+	}
+
+	return &DocumentSet{Docs: docs}
+}
+
+// invalidPositions returns the sorted positions of the documents that are not
+// JSON objects. The indexes leave them out, so the invalid JSON policy is
+// applied to them apart.
+func (s *DocumentSet) invalidPositions() []int {
+	s.invalidScanned.Do(func() {
+		for n := range s.Docs {
+			if s.Docs[n].Err != nil {
+				s.invalid = append(s.invalid, n)
+			}
+		}
+	})
+
+	return s.invalid
+}
+
+// valid reports whether every document is a JSON object.
+func (s *DocumentSet) valid() bool {
+	for n := range s.Docs {
+		if s.Docs[n].Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// json renders the document for a JSON array, the stored text as a JSON string
+// when it is not JSON at all.
+func (d *Document) json() string {
+	if d.Err == nil || json.Valid([]byte(d.Raw)) {
+		return d.Raw
+	}
+
+	quoted, _ := json.Marshal(d.Raw)
+	return string(quoted)
+}
+
+// validate checks every document against the schema on first use.
+func (s *DocumentSet) validate(documentSchema *schema.Schema) {
+	s.validated.Do(func() {
+		for n := range s.Docs {
+			if s.Docs[n].Err == nil {
+				s.Docs[n].violations = documentSchema.Validate(s.Docs[n].Value)
+			}
+		}
+	})
+}
+
+// annotated adds the schema violations to the stored document as _schemaErrors,
+// leaving the rest of it as stored.
+func (d *Document) annotated() string {
+	errors, _ := json.Marshal(d.violations)
+	body := strings.TrimLeft(d.Raw, " \t\r\n")[1:]
+	if strings.HasPrefix(strings.TrimLeft(body, " \t\r\n"), "}") {
+		return `{"_schemaErrors":` + string(errors) + body
+	}
+
+	return `{"_schemaErrors":` + string(errors) + "," + body
+}
+
+// Ids returns the ids of the documents, "" for those not known.
+func (s *DocumentSet) Ids() []string {
+	ids := make([]string, 0, len(s.Docs))
+	for _, doc := range s.Docs {
+		ids = append(ids, doc.Id)
+	}
+
+	return ids
+}
+
+// Raw returns the documents as stored.
+func (s *DocumentSet) Raw() []string {
+	raw := make([]string, 0, len(s.Docs))
+	for _, doc := range s.Docs {
+		raw = append(raw, doc.Raw)
+	}
+
+	return raw
+}
+
+// Rough memory costs of parsed values on a 64-bit runtime, close enough to
+// keep a snapshot's accounting honest without walking the heap.
+const (
+	mapCost      = 48
+	mapEntryCost = 40
+	sliceCost    = 24
+	elementCost  = 16
+	scalarCost   = 8
+)
+
+// cost estimates the memory the parsed values and the indexes add to the raw
+// documents, which are shared with the snapshot's data and not counted again.
+func (s *DocumentSet) cost() int64 {
+	cost := int64(len(s.Docs)) * int64(unsafe.Sizeof(Document{}))
+	for n := range s.Docs {
+		if s.Docs[n].Value != nil {
+			cost += valueCost(s.Docs[n].Value)
+		}
+	}
+	for field, index := range s.indexes {
+		cost += int64(len(field)) + index.cost()
+	}
+	if s.search != nil {
+		cost += s.search.cost()
+	}
+
+	return cost
+}
+
+func valueCost(value interface{}) int64 {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		cost := int64(mapCost)
+		for key, element := range value {
+			cost += mapEntryCost + int64(len(key)) + valueCost(element)
+		}
+		return cost
+	case []interface{}:
+		cost := int64(sliceCost)
+		for _, element := range value {
+			cost += elementCost + valueCost(element)
+		}
+		return cost
+	case string:
+		return int64(len(value))
+	default:
+		return scalarCost
+	}
+}
@@ -0,0 +1,496 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParamFilter carries a boolean filter expression, e.g.
+// ?_filter=color = "red" OR (price < 100 AND brand = "X").
+//
+//	expression := or
+//	or         := and { ("OR" | "||") and }
+//	and        := not { ("AND" | "&&") not }
+//	not        := ("NOT" | "!") not | "(" expression ")" | comparison | call
+//	comparison := field ("=" | "!=" | "<" | "<=" | ">" | ">=") literal
+//	call       := ("contains" | "startsWith" | "endsWith") "(" field "," string ")"
+//	literal    := string | number | "true" | "false" | "null"
+//
+// Fields are paths like in simple parameters, including brackets such as
+// wheels[any].size or tags[len]. Keywords are case-insensitive,
+// strings use single or double quotes with backslash escapes.
+const ParamFilter = "_filter"
+
+// ExpressionError reports where a filter expression went wrong. Position counts
+// characters from 1.
+type ExpressionError struct {
+	Position int
+	Message  string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("%s: %s at position %d", ParamFilter, e.Message, e.Position)
+}
+
+func (e *ExpressionError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// expression is a parsed filter, evaluated against one document.
+type expression interface {
+	matches(doc map[string]interface{}) bool
+}
+
+type andExpression struct{ left, right expression }
+
+func (e andExpression) matches(doc map[string]interface{}) bool {
+	return e.left.matches(doc) && e.right.matches(doc)
+}
+
+type orExpression struct{ left, right expression }
+
+func (e orExpression) matches(doc map[string]interface{}) bool {
+	return e.left.matches(doc) || e.right.matches(doc)
+}
+
+type notExpression struct{ operand expression }
+
+func (e notExpression) matches(doc map[string]interface{}) bool {
+	return !e.operand.matches(doc)
+}
+
+// comparison compares a field with a literal of the same JSON type. A missing
+// field or a type mismatch never matches, except for != which is the negation of =.
+type comparison struct {
+	fieldPath path
+	operator  string
+	value     interface{}
+}
+
+func (e comparison) matches(doc map[string]interface{}) bool {
+	equal := func(value interface{}) bool { return equalValues(value, e.value) }
+	switch e.operator {
+	case "=":
+		return e.fieldPath.match(doc, equal)
+	case "!=":
+		return !e.fieldPath.match(doc, equal)
+	default:
+		return e.fieldPath.match(doc, e.ordered)
+	}
+}
+
+// ordered checks one value against <, <=, > or >=.
+func (e comparison) ordered(value interface{}) bool {
+	var order int
+	switch literal := e.value.(type) {
+	case float64:
+		number, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		order = compareFloats(number, literal)
+	case string:
+		text, ok := value.(string)
+		if !ok {
+			return false
+		}
+		order = strings.Compare(text, literal)
+	default:
+		return false
+	}
+
+	switch e.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+func equalValues(value interface{}, literal interface{}) bool {
+	switch literal.(type) {
+	case float64, string, bool, nil:
+		return value == literal
+	default:
+		return false
+	}
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// call applies a string function to a field, non-string values never match.
+type call struct {
+	function  string
+	fieldPath path
+	argument  string
+}
+
+var stringFunctions = map[string]func(s string, substr string) bool{
+	"contains":   strings.Contains,
+	"startswith": strings.HasPrefix,
+	"endswith":   strings.HasSuffix,
+}
+
+func (e call) matches(doc map[string]interface{}) bool {
+	return e.fieldPath.match(doc, func(value interface{}) bool {
+		text, ok := value.(string)
+		return ok && stringFunctions[e.function](text, e.argument)
+	})
+}
+
+// parseExpression parses a _filter value into something that can be evaluated per document.
+func parseExpression(input string) (expression, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEnd {
+		return nil, p.errorAt(next, "unexpected "+next.describe())
+	}
+
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+var twoCharOperators = map[string]bool{"!=": true, "<=": true, ">=": true, "&&": true, "||": true}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	position := 0
+	for offset := 0; offset < len(input); {
+		char, size := utf8.DecodeRuneInString(input[offset:])
+		position++
+		start := position
+
+		switch {
+		case unicode.IsSpace(char):
+			offset += size
+		case char == '(' || char == ')' || char == ',':
+			kind := map[rune]tokenKind{'(': tokenOpen, ')': tokenClose, ',': tokenComma}[char]
+			tokens = append(tokens, token{kind: kind, text: string(char), position: start})
+			offset += size
+		case strings.ContainsRune("=!<>&|", char):
+			text := string(char)
+			if offset+1 < len(input) && twoCharOperators[input[offset:offset+2]] {
+				text = input[offset : offset+2]
+			}
+			if text == "&" || text == "|" {
+				return nil, &ExpressionError{Position: start, Message: "expected '" + text + text + "'"}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: text, position: start})
+			offset += len(text)
+			position += len(text) - 1
+		case char == '"' || char == '\'':
+			text, consumed, err := readString(input[offset:], start)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, position: start})
+			position += utf8.RuneCountInString(input[offset:offset+consumed]) - 1
+			offset += consumed
+		case char == '-' || unicode.IsDigit(char):
+			end := offset + size
+			for end < len(input) && strings.ContainsRune("0123456789.eE+-", rune(input[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[offset:end], position: start})
+			position += end - offset - 1
+			offset = end
+		case char == '_' || unicode.IsLetter(char):
+			end := offset + size
+			for end < len(input) {
+				next, nextSize := utf8.DecodeRuneInString(input[end:])
+				if !strings.ContainsRune("_.[]", next) && !unicode.IsLetter(next) && !unicode.IsDigit(next) {
+					break
+				}
+				end += nextSize
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: input[offset:end], position: start})
+			position += utf8.RuneCountInString(input[offset:end]) - 1
+			offset = end
+		default:
+			return nil, &ExpressionError{Position: start, Message: fmt.Sprintf("unexpected character %q", char)}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEnd, position: position + 1}), nil
+}
+
+// readString reads a quoted string at the start of input and returns its value
+// and the number of bytes it took.
+func readString(input string, position int) (string, int, error) {
+	quote := input[0]
+	var value strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(input) {
+				break
+			}
+			i++
+			value.WriteByte(input[i])
+		default:
+			value.WriteByte(input[i])
+		}
+	}
+
+	return "", 0, &ExpressionError{Position: position, Message: "unterminated string"}
+}
+
+// maxExpressionDepth bounds how deep parentheses and NOT may nest in a _filter.
+const maxExpressionDepth = 64
+
+type parser struct {
+	tokens []token
+	next   int
+	// depth counts the parentheses and NOT enclosing the current token
+	depth int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) errorAt(t token, message string) error {
+	return &ExpressionError{Position: t.position, Message: message}
+}
+
+func (p *parser) isKeyword(t token, keyword string, symbol string) bool {
+	return (t.kind == tokenIdentifier && strings.EqualFold(t.text, keyword)) || (t.kind == tokenOperator && t.text == symbol)
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(p.peek(), "OR", "||") {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpression{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(p.peek(), "AND", "&&") {
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpression{left, right}
+	}
+
+	return left, nil
+}
+
+// nest enters a parenthesis or NOT at t, failing past maxExpressionDepth.
+// The caller leaves it again with p.depth--.
+func (p *parser) nest(t token) error {
+	if p.depth == maxExpressionDepth {
+		return p.errorAt(t, fmt.Sprintf("expression nested deeper than %d levels", maxExpressionDepth))
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) parseNot() (expression, error) {
+	next := p.peek()
+	switch {
+	case p.isKeyword(next, "NOT", "!"):
+		if err := p.nest(next); err != nil {
+			return nil, err
+		}
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return notExpression{operand}, nil
+	case next.kind == tokenOpen:
+		if err := p.nest(next); err != nil {
+			return nil, err
+		}
+		p.advance()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		if closing := p.advance(); closing.kind != tokenClose {
+			return nil, p.errorAt(closing, "expected ')' but found "+closing.describe())
+		}
+		return inner, nil
+	case next.kind == tokenIdentifier:
+		p.advance()
+		if p.peek().kind == tokenOpen {
+			return p.parseCall(next)
+		}
+		return p.parseComparison(next)
+	default:
+		return nil, p.errorAt(next, "expected a field, function or '(' but found "+next.describe())
+	}
+}
+
+func (p *parser) parseComparison(field token) (expression, error) {
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+
+	op := p.advance()
+	switch op.text {
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, p.errorAt(op, "expected a comparison operator but found "+op.describe())
+	}
+
+	literal := p.advance()
+	value, err := p.literalValue(literal)
+	if err != nil {
+		return nil, err
+	}
+	if op.text != "=" && op.text != "!=" {
+		switch value.(type) {
+		case float64, string:
+		default:
+			return nil, p.errorAt(literal, op.text+" needs a number or a string")
+		}
+	}
+
+	return comparison{fieldPath: buildPath(field.text), operator: op.text, value: value}, nil
+}
+
+func (p *parser) literalValue(literal token) (interface{}, error) {
+	switch literal.kind {
+	case tokenString:
+		return literal.text, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(literal.text, 64)
+		if err != nil {
+			return nil, p.errorAt(literal, "invalid number "+literal.describe())
+		}
+		return number, nil
+	case tokenIdentifier:
+		switch strings.ToLower(literal.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+
+	return nil, p.errorAt(literal, "expected a value but found "+literal.describe())
+}
+
+func (p *parser) parseCall(name token) (expression, error) {
+	function := strings.ToLower(name.text)
+	if _, found := stringFunctions[function]; !found {
+		return nil, p.errorAt(name, "unknown function '"+name.text+"'")
+	}
+	p.advance()
+
+	field := p.advance()
+	if field.kind != tokenIdentifier {
+		return nil, p.errorAt(field, "expected a field but found "+field.describe())
+	}
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+	if comma := p.advance(); comma.kind != tokenComma {
+		return nil, p.errorAt(comma, "expected ',' but found "+comma.describe())
+	}
+	argument := p.advance()
+	if argument.kind != tokenString {
+		return nil, p.errorAt(argument, "expected a string but found "+argument.describe())
+	}
+	if closing := p.advance(); closing.kind != tokenClose {
+		return nil, p.errorAt(closing, "expected ')' but found "+closing.describe())
+	}
+
+	return call{function: function, fieldPath: buildPath(field.text), argument: argument.text}, nil
+}
+
+func checkField(field token) error {
+	for _, part := range strings.Split(field.text, ".") {
+		if part == "" {
+			return &ExpressionError{Position: field.position, Message: "invalid field " + field.describe()}
+		}
+	}
+
+	return nil
+}
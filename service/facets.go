@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	// ParamFields lists the fields to count values of, e.g. ?_fields=color,owner.city.
+	ParamFields = "_fields"
+	// ParamLimit keeps the N most frequent values per facet.
+	ParamLimit = "_limit"
+)
+
+// FacetValue is one distinct value of a field and the number of documents holding it.
+type FacetValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// Facets filters docs by the query parameters and counts the distinct values
+// of each requested field, most frequent first. Fields are read like filter
+// paths: a document counts once for every distinct value it holds, missing
+// fields, null and values that are objects are not counted.
+func (s *QueryService) Facets(ctx context.Context, queryParams map[string][]string, docs *DocumentSet) (map[string][]FacetValue, error) {
+	filterParams := make(map[string][]string, len(queryParams))
+	for key, values := range queryParams {
+		filterParams[key] = values
+	}
+	delete(filterParams, ParamFields)
+	delete(filterParams, ParamLimit)
+
+	fields := splitList(queryParams[ParamFields])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %s names no field", ErrInvalidQuery, ParamFields)
+	}
+	limit := 0
+	if values := queryParams[ParamLimit]; len(values) > 0 {
+		parsed, err := strconv.Atoi(values[0])
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("%w: %s must be a positive number, got %q", ErrInvalidQuery, ParamLimit, values[0])
+		}
+		limit = parsed
+	}
+
+	matching, err := s.matching(ctx, filterParams, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := make(map[string][]FacetValue, len(fields))
+	for _, field := range fields {
+		fieldPath := buildPath(field)
+		counts := make(map[interface{}]int)
+		for _, doc := range matching {
+			if doc.Err != nil {
+				continue
+			}
+			for _, value := range distinctValues(fieldPath.values(doc.Value)) {
+				switch value.(type) {
+				case string, float64, bool:
+					counts[value]++
+				}
+			}
+		}
+
+		values := make([]FacetValue, 0, len(counts))
+		for value, count := range counts {
+			values = append(values, FacetValue{Value: value, Count: count})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return compareValues(values[i].Value, values[j].Value) < 0
+		})
+		if limit > 0 && len(values) > limit {
+			values = values[:limit]
+		}
+
+		facets[field] = values
+	}
+
+	return facets, nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"unsafe"
+)
+
+// fieldIndex maps the values found under one field path to document positions
+// in a DocumentSet. Both lists are built at warm-up and never change afterwards.
+type fieldIndex struct {
+	// byValue holds the documents with a string, boolean, null or object value, by indexKey
+	byValue map[string][]int
+	// numeric holds the documents with a numeric value, ascending by value
+	numeric []numericPosting
+}
+
+type numericPosting struct {
+	value    float64
+	position int
+}
+
+func buildIndexes(fields []string, docs []Document) map[string]*fieldIndex {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	indexes := make(map[string]*fieldIndex, len(fields))
+	for _, field := range fields {
+		fieldPath := buildPath(field)
+		index := &fieldIndex{byValue: make(map[string][]int)}
+		for position, doc := range docs {
+			if doc.Value == nil {
+				continue
+			}
+
+			// a document is listed once per value it holds, e.g. once per tag
+			for _, value := range fieldPath.values(doc.Value) {
+				if number, ok := value.(float64); ok {
+					index.numeric = append(index.numeric, numericPosting{value: number, position: position})
+				} else if key, ok := indexKey(value); ok {
+					index.byValue[key] = append(index.byValue[key], position)
+				}
+			}
+		}
+
+		sort.SliceStable(index.numeric, func(i, j int) bool {
+			return index.numeric[i].value < index.numeric[j].value
+		})
+		indexes[field] = index
+	}
+
+	return indexes
+}
+
+// positions returns the sorted positions of the documents matching f.
+func (i *fieldIndex) positions(f *filter) []int {
+	var positions []int
+	switch f.operator {
+	case operatorEqual:
+		for _, value := range f.values {
+			for _, key := range value.indexKeys() {
+				positions = append(positions, i.byValue[key]...)
+			}
+			if value.isNumber {
+				positions = append(positions, i.numericEqual(value.number)...)
+			}
+		}
+	default:
+		positions = i.numericRange(f)
+	}
+
+	sort.Ints(positions)
+	return uniqueSorted(positions)
+}
+
+func uniqueSorted(positions []int) []int {
+	unique := positions[:0]
+	for _, position := range positions {
+		if len(unique) == 0 || position != unique[len(unique)-1] {
+			unique = append(unique, position)
+		}
+	}
+
+	return unique
+}
+
+// indexKey tells apart values of different types that render alike, the string
+// "true" and the boolean true for instance. Numbers are kept in numeric instead.
+func indexKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return "s" + v, true
+	case bool:
+		return "b" + strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	case map[string]interface{}:
+		// encoding/json sorts object keys, so equal objects encode alike
+		encoded, err := json.Marshal(v)
+		return "o" + string(encoded), err == nil
+	default:
+		return "", false
+	}
+}
+
+// indexKeys returns the keys of every non-numeric value the parameter equals.
+func (p *parameter) indexKeys() []string {
+	keys := []string{"s" + p.text}
+	switch p.text {
+	case "true", "false":
+		keys = append(keys, "b"+p.text)
+	case "null":
+		keys = append(keys, "null")
+	}
+	if p.object != nil {
+		if key, ok := indexKey(p.object); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// numericEqual returns the positions of the values equal to number.
+func (i *fieldIndex) numericEqual(number float64) []int {
+	from := sort.Search(len(i.numeric), func(n int) bool { return i.numeric[n].value >= number })
+	var positions []int
+	for n := from; n < len(i.numeric) && i.numeric[n].value == number; n++ {
+		positions = append(positions, i.numeric[n].position)
+	}
+
+	return positions
+}
+
+// numericRange returns the positions of the values within all bounds of f.
+func (i *fieldIndex) numericRange(f *filter) []int {
+	from, to := 0, len(i.numeric)
+	for _, bound := range f.bounds {
+		switch f.operator {
+		case operatorGreater:
+			from = max(from, sort.Search(len(i.numeric), func(n int) bool { return i.numeric[n].value > bound }))
+		case operatorGreaterOrEqual:
+			from = max(from, sort.Search(len(i.numeric), func(n int) bool { return i.numeric[n].value >= bound }))
+		case operatorLess:
+			to = min(to, sort.Search(len(i.numeric), func(n int) bool { return i.numeric[n].value >= bound }))
+		case operatorLessOrEqual:
+			to = min(to, sort.Search(len(i.numeric), func(n int) bool { return i.numeric[n].value > bound }))
+		}
+	}
+
+	positions := make([]int, 0, max(to-from, 0))
+	for n := from; n < to; n++ {
+		positions = append(positions, i.numeric[n].position)
+	}
+
+	return positions
+}
+
+// candidates narrows the set down with the indexed filters. It returns the
+// sorted positions left and the filters that still have to be checked per
+// document. A nil positions slice means no index applied and every document is
+// a candidate.
+func (s *DocumentSet) candidates(filters []filter) ([]int, []filter) {
+	var positions []int
+	indexed := false
+	remaining := make([]filter, 0, len(filters))
+	for n := range filters {
+		index, found := s.indexes[filters[n].field]
+		// case-insensitive comparisons are not indexed
+		if !found || filters[n].operator == operatorEqualFold {
+			remaining = append(remaining, filters[n])
+			continue
+		}
+
+		matching := index.positions(&filters[n])
+		if !indexed {
+			positions, indexed = matching, true
+		} else {
+			positions = intersectSorted(positions, matching)
+		}
+	}
+
+	if indexed && positions == nil {
+		positions = []int{}
+	}
+	return positions, remaining
+}
+
+func intersectSorted(a []int, b []int) []int {
+	result := make([]int, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+func unionSorted(a []int, b []int) []int {
+	result := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+
+	return append(result, b[j:]...)
+}
+
+// cost estimates the memory of the index, see DocumentSet.cost.
+func (i *fieldIndex) cost() int64 {
+	cost := int64(mapCost + sliceCost + len(i.numeric)*int(unsafe.Sizeof(numericPosting{})))
+	for key, positions := range i.byValue {
+		cost += mapEntryCost + int64(len(key)) + sliceCost + int64(len(positions))*scalarCost
+	}
+
+	return cost
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IdParam is the placeholder of a key template that stands for the document id.
+const IdParam = "id"
+
+// globChars are special in KEYS patterns and must not reach one through a path parameter.
+const globChars = `*?[]\`
+
+var ErrInvalidPathParam = errors.New("invalid path parameter")
+
+// KeyTemplate builds the Redis keys of a prefix from a pattern such as
+// "tenant:{tenant}:order:{id}". Placeholders other than {id} are bound to path
+// parameters per request, {id} is the document id.
+type KeyTemplate struct {
+	segments []keySegment
+}
+
+// keySegment is either literal text or a placeholder, when param is set.
+type keySegment struct {
+	literal string
+	param   string
+}
+
+func ParseKeyTemplate(pattern string) (KeyTemplate, error) {
+	var template KeyTemplate
+	ids := 0
+	for rest := pattern; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			template.segments = append(template.segments, keySegment{literal: rest})
+			break
+		}
+		if open > 0 {
+			template.segments = append(template.segments, keySegment{literal: rest[:open]})
+		}
+
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return KeyTemplate{}, fmt.Errorf("unclosed placeholder in %q", pattern)
+		}
+		param := rest[open+1 : open+closing]
+		if param == "" || strings.ContainsAny(param, "{"+globChars) {
+			return KeyTemplate{}, fmt.Errorf("invalid placeholder {%s} in %q", param, pattern)
+		}
+		if param == IdParam {
+			ids++
+		}
+
+		template.segments = append(template.segments, keySegment{param: param})
+		rest = rest[open+closing+1:]
+	}
+
+	if ids != 1 {
+		return KeyTemplate{}, fmt.Errorf("%q must contain {%s} exactly once", pattern, IdParam)
+	}
+
+	return template, nil
+}
+
+// PrefixTemplate is the template of a plain redis_prefix, the prefix followed by the id.
+func PrefixTemplate(prefix string) KeyTemplate {
+	return KeyTemplate{segments: []keySegment{{literal: prefix}, {param: IdParam}}}
+}
+
+// Params returns the placeholders that are bound to path parameters.
+func (t KeyTemplate) Params() []string {
+	var params []string
+	for _, segment := range t.segments {
+		if segment.param != "" && segment.param != IdParam {
+			params = append(params, segment.param)
+		}
+	}
+
+	return params
+}
+
+// Bind fills in path parameters. Values containing glob characters are rejected,
+// they would widen the keyspace a collection scan covers.
+func (t KeyTemplate) Bind(params map[string]string) (KeyTemplate, error) {
+	bound := KeyTemplate{segments: make([]keySegment, 0, len(t.segments))}
+	for _, segment := range t.segments {
+		if value, found := params[segment.param]; found && segment.param != IdParam {
+			if value == "" || strings.ContainsAny(value, globChars) {
+				return KeyTemplate{}, fmt.Errorf("%w: %s must not be empty or contain any of %s", ErrInvalidPathParam, segment.param, globChars)
+			}
+			segment = keySegment{literal: value}
+		}
+		bound.segments = append(bound.segments, segment)
+	}
+
+	return bound, nil
+}
+
+// Key returns the key of the document id.
+func (t KeyTemplate) Key(id string) string {
+	var key strings.Builder
+	for _, segment := range t.segments {
+		if segment.param == IdParam {
+			key.WriteString(id)
+		} else {
+			key.WriteString(segment.literal)
+		}
+	}
+
+	return key.String()
+}
+
+// Id returns the id of the document stored under key. It fails for keys the
+// template does not produce and while placeholders other than {id} are unbound.
+func (t KeyTemplate) Id(key string) (string, bool) {
+	var prefix, suffix strings.Builder
+	seen := false
+	for _, segment := range t.segments {
+		switch {
+		case segment.param == IdParam:
+			seen = true
+		case segment.param != "":
+			return "", false
+		case seen:
+			suffix.WriteString(segment.literal)
+		default:
+			prefix.WriteString(segment.literal)
+		}
+	}
+
+	id, found := strings.CutPrefix(key, prefix.String())
+	if !found {
+		return "", false
+	}
+	return strings.CutSuffix(id, suffix.String())
+}
+
+// Pattern is the KEYS pattern matching every document the template can address.
+// Literal text is escaped, unbound placeholders match anything.
+func (t KeyTemplate) Pattern() string {
+	var pattern strings.Builder
+	for _, segment := range t.segments {
+		if segment.param != "" {
+			pattern.WriteString("*")
+			continue
+		}
+		for _, char := range segment.literal {
+			if strings.ContainsRune(globChars, char) {
+				pattern.WriteRune('\\')
+			}
+			pattern.WriteRune(char)
+		}
+	}
+
+	return pattern.String()
+}
+
+// String renders the template as configured.
+func (t KeyTemplate) String() string {
+	var rendered strings.Builder
+	for _, segment := range t.segments {
+		if segment.param != "" {
+			rendered.WriteString("{" + segment.param + "}")
+		} else {
+			rendered.WriteString(segment.literal)
+		}
+	}
+
+	return rendered.String()
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// ResponseMeta carries facts about how a result was produced back to the HTTP layer,
+// which turns them into response headers.
+type ResponseMeta struct {
+	// Stale is set when the result comes from an expired cache snapshot
+	// because Redis is unavailable.
+	Stale bool
+	// Generation and BuiltAt identify the cache snapshot the result was read from.
+	// Generation is zero for results read straight from Redis.
+	Generation uint64
+	BuiltAt    time.Time
+	// Digest fingerprints the content of the snapshot and ModifiedAt is when that
+	// content was first seen. Both are zero for results read straight from Redis.
+	Digest     uint64
+	ModifiedAt time.Time
+}
+
+type responseMetaKey struct{}
+
+func WithResponseMeta(ctx context.Context) (context.Context, *ResponseMeta) {
+	meta := &ResponseMeta{}
+	return context.WithValue(ctx, responseMetaKey{}, meta), meta
+}
+
+// responseMeta never returns nil, callers without WithResponseMeta get a throwaway value.
+func responseMeta(ctx context.Context) *ResponseMeta {
+	if meta, ok := ctx.Value(responseMetaKey{}).(*ResponseMeta); ok {
+		return meta
+	}
+
+	return &ResponseMeta{}
+}
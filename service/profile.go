@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// maxProfileExamples bounds the example values reported per field.
+	maxProfileExamples = 3
+	// maxProfileInvalid bounds the invalid documents listed, all of them are counted.
+	maxProfileInvalid = 20
+)
+
+// Profile describes the shape of a sample of a prefix's documents.
+type Profile struct {
+	Prefix string `json:"prefix"`
+	// Keys is the number of keys of the prefix, Sampled how many of them were read.
+	Keys    int            `json:"keys"`
+	Sampled int            `json:"sampled"`
+	Fields  []FieldProfile `json:"fields"`
+	// Invalid counts the sampled documents that are not valid JSON, InvalidDocuments lists the first of them.
+	Invalid          int               `json:"invalid"`
+	InvalidDocuments []InvalidDocument `json:"invalidDocuments"`
+
+	root *fieldStats
+}
+
+// FieldProfile describes one field path, elements of arrays are written as in
+// queries, e.g. wheels[any].size.
+type FieldProfile struct {
+	Path string `json:"path"`
+	// Types counts the values by JSON type, telling integers apart from other numbers.
+	Types map[string]int `json:"types"`
+	// Presence is the share of the enclosing objects holding the field.
+	Presence float64 `json:"presence"`
+	NullRate float64 `json:"nullRate"`
+	// Cardinality is the number of distinct strings, numbers and booleans in the sample.
+	Cardinality int           `json:"cardinality"`
+	Examples    []interface{} `json:"examples"`
+}
+
+type InvalidDocument struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// ProfileDocuments reads up to size documents picked at random among the keys
+// of source and infers their fields.
+func ProfileDocuments(ctx context.Context, name string, source *JsonServiceImpl, size int) (*Profile, error) {
+	keys, err := source.GetAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := keys
+	if len(keys) > size {
+		sample = make([]string, len(keys))
+		copy(sample, keys)
+		rand.Shuffle(len(sample), func(i, j int) {
+			sample[i], sample[j] = sample[j], sample[i]
+		})
+		sample = sample[:size]
+	}
+	sort.Strings(sample)
+
+	docs, err := source.GetByKeys(ctx, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Profile{Prefix: name, Keys: len(keys), InvalidDocuments: []InvalidDocument{}, root: newFieldStats("")}
+	for i, raw := range docs {
+		// deleted since the keys were listed
+		if raw == "" {
+			continue
+		}
+
+		profile.Sampled++
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			profile.Invalid++
+			if len(profile.InvalidDocuments) < maxProfileInvalid {
+				profile.InvalidDocuments = append(profile.InvalidDocuments, InvalidDocument{Key: sample[i], Error: err.Error()})
+			}
+			continue
+		}
+		profile.root.add(value)
+	}
+
+	profile.Fields = profile.root.fields(make([]FieldProfile, 0))
+	return profile, nil
+}
+
+// Schema renders the inferred shape as a JSON Schema. Fields held by every
+// enclosing object are required.
+func (p *Profile) Schema() map[string]interface{} {
+	schema := p.root.schema()
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = p.Prefix
+
+	return schema
+}
+
+// fieldStats accumulates the values seen at one path.
+type fieldStats struct {
+	path  string
+	types map[string]int
+	count int
+	nulls int
+	// appearances counts the enclosing objects holding the field
+	appearances int
+	distinct    map[uint64]bool
+	examples    []interface{}
+	properties  map[string]*fieldStats
+	elements    *fieldStats
+}
+
+func newFieldStats(path string) *fieldStats {
+	return &fieldStats{path: path, types: make(map[string]int), distinct: make(map[uint64]bool)}
+}
+
+func (f *fieldStats) add(value interface{}) {
+	f.count++
+	f.types[profileType(value)]++
+
+	switch v := value.(type) {
+	case nil:
+		f.nulls++
+	case map[string]interface{}:
+		for name, property := range v {
+			child, found := f.properties[name]
+			if !found {
+				path := name
+				if f.path != "" {
+					path = f.path + "." + name
+				}
+				child = newFieldStats(path)
+				if f.properties == nil {
+					f.properties = make(map[string]*fieldStats)
+				}
+				f.properties[name] = child
+			}
+			child.appearances++
+			child.add(property)
+		}
+	case []interface{}:
+		if f.elements == nil {
+			f.elements = newFieldStats(f.path + "[any]")
+		}
+		// the share of non-empty arrays is the presence of elements
+		if len(v) > 0 {
+			f.elements.appearances++
+		}
+		for _, element := range v {
+			f.elements.add(element)
+		}
+	default:
+		encoded, _ := json.Marshal(v)
+		hash := fnv.New64a()
+		_, _ = hash.Write(encoded)
+		if key := hash.Sum64(); !f.distinct[key] {
+			f.distinct[key] = true
+			if len(f.examples) < maxProfileExamples {
+				f.examples = append(f.examples, v)
+			}
+		}
+	}
+}
+
+// fields lists f's descendants depth first, properties by name.
+func (f *fieldStats) fields(result []FieldProfile) []FieldProfile {
+	objects := f.types["object"]
+	for _, name := range f.propertyNames() {
+		child := f.properties[name]
+		result = append(result, child.profile(objects))
+		result = child.fields(result)
+	}
+	if f.elements != nil {
+		result = append(result, f.elements.profile(f.types["array"]))
+		result = f.elements.fields(result)
+	}
+
+	return result
+}
+
+func (f *fieldStats) profile(enclosing int) FieldProfile {
+	examples := f.examples
+	if examples == nil {
+		examples = []interface{}{}
+	}
+
+	return FieldProfile{
+		Path:        f.path,
+		Types:       f.types,
+		Presence:    ratio(f.appearances, enclosing),
+		NullRate:    ratio(f.nulls, f.count),
+		Cardinality: len(f.distinct),
+		Examples:    examples,
+	}
+}
+
+func (f *fieldStats) propertyNames() []string {
+	names := make([]string, 0, len(f.properties))
+	for name := range f.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (f *fieldStats) schema() map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	types := make([]string, 0, len(f.types))
+	for name := range f.types {
+		// integers are numbers too
+		if name == "integer" && f.types["number"] > 0 {
+			continue
+		}
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+
+	if f.properties != nil {
+		properties := make(map[string]interface{}, len(f.properties))
+		required := make([]string, 0)
+		for _, name := range f.propertyNames() {
+			child := f.properties[name]
+			properties[name] = child.schema()
+			if child.appearances == f.types["object"] {
+				required = append(required, name)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	if f.elements != nil {
+		schema["items"] = f.elements.schema()
+	}
+
+	return schema
+}
+
+func profileType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func ratio(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+
+	return float64(part) / float64(whole)
+}
@@ -1,14 +1,78 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"redis-go-dispatcher/metrics"
+	"redis-go-dispatcher/schema"
 )
 
 type QueryService struct {
-	logger Logger
+	logger       Logger
+	searchFields []searchField
+	invalidJson  InvalidJsonPolicy
+	// invalidDocuments counts the distinct stored documents met that are not JSON objects
+	invalidDocuments *metrics.Counter
+	schema           *schema.Schema
+	schemaPolicy     SchemaPolicy
+	// schemaViolations counts the distinct documents met that do not match the schema
+	schemaViolations *metrics.Counter
+	// counted remembers the bad documents already counted, so that reading one again does not count it twice
+	counted      map[uint64]bool
+	countedMutex sync.Mutex
+}
+
+// InvalidJsonPolicy decides what a collection read does with stored documents
+// that are not JSON objects.
+type InvalidJsonPolicy string
+
+const (
+	// InvalidJsonDefault applies when none is configured. It skips them in
+	// filtered reads and returns unfiltered reads as stored.
+	InvalidJsonDefault InvalidJsonPolicy = ""
+	// InvalidJsonSkip logs and leaves them out.
+	InvalidJsonSkip InvalidJsonPolicy = "skip"
+	// InvalidJsonInclude returns them whatever the filters, as stored when they are
+	// valid JSON and as a JSON string holding the stored text otherwise.
+	InvalidJsonInclude InvalidJsonPolicy = "include"
+	// InvalidJsonFail fails the request with ErrInvalidDocument.
+	InvalidJsonFail InvalidJsonPolicy = "fail"
+)
+
+// ErrInvalidDocument is returned when a stored document cannot be read under InvalidJsonFail.
+var ErrInvalidDocument = errors.New("invalid stored document")
+
+// SchemaPolicy decides what a read does with documents that do not match the
+// prefix's schema. Violations are counted whatever the policy.
+type SchemaPolicy string
+
+const (
+	// SchemaPolicyDrop leaves them out, a single document read finds nothing.
+	SchemaPolicyDrop SchemaPolicy = "drop"
+	// SchemaPolicyAnnotate adds the violations to them as _schemaErrors.
+	SchemaPolicyAnnotate SchemaPolicy = "annotate"
+	// SchemaPolicyPass returns them as stored.
+	SchemaPolicyPass SchemaPolicy = "pass"
+)
+
+type QuerySettings struct {
+	Name string
+	// SearchFields are the fields _q searches, as configured
+	SearchFields []string
+	InvalidJson  InvalidJsonPolicy
+	// Schema is nil when the prefix has none
+	Schema       *schema.Schema
+	SchemaPolicy SchemaPolicy
 }
 
 type Logger interface {
@@ -16,65 +80,428 @@ type Logger interface {
 	Error(i ...interface{})
 }
 
-func NewQueryService(logger Logger) *QueryService {
-	return &QueryService{logger: logger}
+func NewQueryService(logger Logger, settings QuerySettings) *QueryService {
+	return &QueryService{
+		logger:           logger,
+		searchFields:     parseSearchFields(settings.SearchFields),
+		invalidJson:      settings.InvalidJson,
+		invalidDocuments: metrics.NewCounter("invalid_documents_total", metrics.Labels{"prefix": settings.Name}),
+		schema:           settings.Schema,
+		schemaPolicy:     settings.SchemaPolicy,
+		schemaViolations: metrics.NewCounter("schema_violations_total", metrics.Labels{"prefix": settings.Name}),
+		counted:          make(map[uint64]bool),
+	}
+}
+
+// ErrInvalidQuery is returned for query parameters that cannot be evaluated.
+var ErrInvalidQuery = errors.New("invalid query")
+
+type operator string
+
+const (
+	operatorEqual          operator = ""
+	operatorGreater        operator = "gt"
+	operatorGreaterOrEqual operator = "gte"
+	operatorLess           operator = "lt"
+	operatorLessOrEqual    operator = "lte"
+	// operatorEqualFold compares strings case-insensitively, e.g. name[ieq]=bmw
+	operatorEqualFold operator = "ieq"
+)
+
+// Query filters docs by the query parameters and returns the matching documents
+// as stored, with their ids. Filters on indexed fields are answered from the
+// index, the others are checked on what the indexes left.
+func (s *QueryService) Query(ctx context.Context, queryParams map[string][]string, docs *DocumentSet) ([]string, []string, error) {
+	if len(queryParams) == 0 && s.schema == nil {
+		if docs.valid() {
+			return docs.Raw(), docs.Ids(), nil
+		}
+		if s.invalidJson == InvalidJsonDefault {
+			return s.asStored(docs), docs.Ids(), nil
+		}
+	}
+
+	matching, err := s.matching(ctx, queryParams, docs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make([]string, 0, len(matching))
+	ids := make([]string, 0, len(matching))
+	for _, doc := range matching {
+		if len(doc.violations) > 0 && s.schemaPolicy == SchemaPolicyAnnotate {
+			result = append(result, doc.annotated())
+		} else {
+			result = append(result, doc.json())
+		}
+		ids = append(ids, doc.Id)
+	}
+
+	return result, ids, nil
+}
+
+// Check applies the schema policy to a single stored document. It returns the
+// document to send and whether to send it at all.
+func (s *QueryService) Check(raw string) (string, bool) {
+	if s.schema == nil {
+		return raw, true
+	}
+
+	doc := ParseDocument(raw)
+	if doc.Err != nil {
+		return raw, true
+	}
+	if doc.violations = s.schema.Validate(doc.Value); len(doc.violations) == 0 {
+		return raw, true
+	}
+
+	s.countOnce(s.schemaViolations, "schema", doc.Raw)
+	switch s.schemaPolicy {
+	case SchemaPolicyDrop:
+		return "", false
+	case SchemaPolicyAnnotate:
+		return doc.annotated(), true
+	default:
+		return raw, true
+	}
+}
+
+// Validate checks a document against the schema, there are no violations without one.
+func (s *QueryService) Validate(value interface{}) []schema.Error {
+	if s.schema == nil {
+		return nil
+	}
+
+	return s.schema.Validate(value)
 }
 
-func (s *QueryService) ApplyQuery(queryParams map[string][]string, data []string) ([]string, error) {
-	if queryParams == nil || len(queryParams) == 0 {
-		return data, nil
+// asStored renders every document of an unfiltered read, those that are not JSON
+// at all as JSON strings.
+func (s *QueryService) asStored(docs *DocumentSet) []string {
+	result := make([]string, 0, len(docs.Docs))
+	for n := range docs.Docs {
+		if docs.Docs[n].Err != nil {
+			s.countOnce(s.invalidDocuments, "invalid", docs.Docs[n].Raw)
+		}
+		result = append(result, docs.Docs[n].json())
 	}
 
-	filters := s.buildFilters(queryParams)
-	for _, filter := range filters {
-		data = filter.Apply(data)
+	return result
+}
+
+// maxCountedDocuments bounds the memory of countOnce, past it documents may be counted again.
+const maxCountedDocuments = 1 << 16
+
+// countOnce increments counter the first time it sees the bad document raw,
+// kind tells the counters apart.
+func (s *QueryService) countOnce(counter *metrics.Counter, kind string, raw string) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(kind))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(raw))
+	key := hash.Sum64()
+
+	s.countedMutex.Lock()
+	defer s.countedMutex.Unlock()
+
+	if s.counted[key] {
+		return
+	}
+	if len(s.counted) >= maxCountedDocuments {
+		s.counted = make(map[uint64]bool)
+	}
+	s.counted[key] = true
+	counter.Inc()
+}
+
+// invalid applies the invalid JSON policy to doc. It reports whether to keep it.
+func (s *QueryService) invalid(doc *Document) (bool, error) {
+	s.countOnce(s.invalidDocuments, "invalid", doc.Raw)
+	switch s.invalidJson {
+	case InvalidJsonInclude:
+		return true, nil
+	case InvalidJsonFail:
+		return false, fmt.Errorf("%w: %v", ErrInvalidDocument, doc.Err)
+	default:
+		s.logger.Error(doc.Err)
+		return false, nil
+	}
+}
+
+// matching returns the parsed documents passing every filter, in set order or
+// by relevance when searching. Documents that are not JSON objects are handled
+// by the invalid JSON policy, those violating the schema by the schema policy.
+func (s *QueryService) matching(ctx context.Context, queryParams map[string][]string, docs *DocumentSet) ([]*Document, error) {
+	filters, expressions, err := s.buildFilters(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	positions, remaining := docs.candidates(filters)
+
+	var scores map[int]float64
+	if terms := searchTerms(queryParams[ParamSearch]); len(terms) > 0 {
+		if len(s.searchFields) == 0 {
+			return nil, fmt.Errorf("%w: %s needs search_fields on the prefix", ErrInvalidQuery, ParamSearch)
+		}
+		index := docs.search
+		if index == nil {
+			index = buildSearchIndex(s.searchFields, docs.Docs)
+		}
+
+		scores = index.score(terms)
+		if positions == nil {
+			positions = sortedPositions(scores)
+		} else {
+			positions = intersectSorted(positions, sortedPositions(scores))
+		}
+	}
+	count := len(docs.Docs)
+	if positions != nil {
+		// documents that are not JSON objects are in no index, the policy still applies to them
+		positions = unionSorted(positions, docs.invalidPositions())
+		count = len(positions)
+	}
+
+	if s.schema != nil {
+		docs.validate(s.schema)
+	}
+
+	result := make([]*Document, 0)
+	var relevance []float64
+	for n := 0; n < count; n++ {
+		if n%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		position := n
+		if positions != nil {
+			position = positions[n]
+		}
+		doc := &docs.Docs[position]
+		if doc.Err != nil {
+			keep, err := s.invalid(doc)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				result = append(result, doc)
+				relevance = append(relevance, 0)
+			}
+			continue
+		}
+
+		if !matchesAll(remaining, doc.Value) || !matchesExpressions(expressions, doc.Value) {
+			continue
+		}
+		if len(doc.violations) > 0 {
+			s.countOnce(s.schemaViolations, "schema", doc.Raw)
+			if s.schemaPolicy == SchemaPolicyDrop {
+				continue
+			}
+		}
+
+		result = append(result, doc)
+		relevance = append(relevance, scores[position])
+	}
+
+	if scores != nil {
+		ranked := make([]int, len(result))
+		for n := range ranked {
+			ranked[n] = n
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return relevance[ranked[i]] > relevance[ranked[j]] })
+
+		sorted := make([]*Document, 0, len(result))
+		for _, n := range ranked {
+			sorted = append(sorted, result[n])
+		}
+		result = sorted
+	}
+
+	return result, nil
+}
+
+// NormalizeQuery renders query parameters so that requests differing only in
+// parameter or value order map to the same key.
+func NormalizeQuery(queryParams map[string][]string) string {
+	normalized := make(url.Values, len(queryParams))
+	for key, values := range queryParams {
+		sortedValues := append([]string(nil), values...)
+		sort.Strings(sortedValues)
+		normalized[key] = sortedValues
 	}
 
-	return data, nil
+	return normalized.Encode()
 }
 
-func (s *QueryService) buildFilters(queryParams map[string][]string) []filter {
+// buildFilters turns simple parameters into filters and parses every _filter
+// value into an expression. All of them have to match.
+func (s *QueryService) buildFilters(queryParams map[string][]string) ([]filter, []expression, error) {
 	filters := make([]filter, 0, len(queryParams))
+	var expressions []expression
 	for key, values := range queryParams {
-		filters = append(filters, filter{
-			logger:    s.logger,
-			fieldPath: buildPath(key),
-			values:    values,
-		})
+		if key == ParamSearch {
+			continue
+		}
+		if key == ParamFilter {
+			for _, value := range values {
+				expr, err := parseExpression(value)
+				if err != nil {
+					return nil, nil, err
+				}
+				expressions = append(expressions, expr)
+			}
+			continue
+		}
+
+		f, err := buildFilter(key, values)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, expressions, nil
+}
+
+// buildFilter parses one query parameter. A key may end in a range operator,
+// e.g. year[gte]=2020, whose values must then be numbers, or in [ieq].
+func buildFilter(key string, values []string) (filter, error) {
+	f := filter{field: key, operator: operatorEqual}
+	if open := strings.LastIndex(key, "["); open > 0 && strings.HasSuffix(key, "]") {
+		switch op := operator(key[open+1 : len(key)-1]); op {
+		case operatorGreater, operatorGreaterOrEqual, operatorLess, operatorLessOrEqual, operatorEqualFold:
+			f.field, f.operator = key[:open], op
+		}
+	}
+	f.fieldPath = buildPath(f.field)
+
+	if f.operator == operatorEqual || f.operator == operatorEqualFold {
+		for _, value := range values {
+			f.values = append(f.values, parseParameter(value))
+		}
+		return f, nil
+	}
+
+	for _, value := range values {
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return filter{}, fmt.Errorf("%w: %s expects a number, got %q", ErrInvalidQuery, key, value)
+		}
+		f.bounds = append(f.bounds, bound)
 	}
 
-	return filters
+	return f, nil
 }
 
 type filter struct {
-	logger    Logger
+	// field is the dotted path as given in the query, it names the index to use
+	field     string
 	fieldPath path
-	values    []string
+	operator  operator
+	values    []parameter
+	bounds    []float64
+}
+
+// parameter is a value of an equality filter, read in advance as each JSON type would.
+type parameter struct {
+	text     string
+	number   float64
+	isNumber bool
+	// object is set when text is a JSON object
+	object map[string]interface{}
 }
 
-func (f *filter) Apply(data []string) []string {
-	if len(data) == 0 {
-		return data
+func parseParameter(text string) parameter {
+	p := parameter{text: text}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		p.number, p.isNumber = number, true
+	}
+	if strings.HasPrefix(strings.TrimSpace(text), "{") {
+		_ = json.Unmarshal([]byte(text), &p.object)
 	}
 
-	result := make([]string, 0, len(data))
-	for _, jsonString := range data {
-		jsonMap := make(map[string]interface{})
-		err := json.Unmarshal([]byte(jsonString), &jsonMap)
-		if err != nil {
-			f.logger.Error(err)
-			continue
+	return p
+}
+
+// equals compares a JSON value with the parameter according to the value's type:
+// numbers numerically, so 1.0 matches 1, null as "null", booleans as "true" or
+// "false" and objects by content. foldCase ignores the case of strings.
+func (p *parameter) equals(value interface{}, foldCase bool) bool {
+	switch v := value.(type) {
+	case string:
+		if foldCase {
+			return strings.EqualFold(v, p.text)
+		}
+		return v == p.text
+	case float64:
+		return p.isNumber && p.number == v
+	case bool:
+		return p.text == strconv.FormatBool(v)
+	case nil:
+		return p.text == "null"
+	case map[string]interface{}:
+		return p.object != nil && reflect.DeepEqual(p.object, v)
+	default:
+		return false
+	}
+}
+
+func matchesAll(filters []filter, doc map[string]interface{}) bool {
+	for n := range filters {
+		if !filters[n].matches(doc) {
+			return false
 		}
+	}
+
+	return true
+}
 
-		if val, ok := f.fieldPath.findValue(jsonMap); ok {
-			valueString := convertToString(val)
+func matchesExpressions(expressions []expression, doc map[string]interface{}) bool {
+	for _, expr := range expressions {
+		if !expr.matches(doc) {
+			return false
+		}
+	}
 
-			if Contains(f.values, valueString) {
-				result = append(result, jsonString)
+	return true
+}
+
+func (f *filter) matches(doc map[string]interface{}) bool {
+	if f.operator == operatorEqual || f.operator == operatorEqualFold {
+		return f.fieldPath.match(doc, func(value interface{}) bool {
+			for n := range f.values {
+				if f.values[n].equals(value, f.operator == operatorEqualFold) {
+					return true
+				}
 			}
+			return false
+		})
+	}
+
+	return f.fieldPath.match(doc, f.inBounds)
+}
+
+// inBounds checks one value against all bounds of a range filter.
+func (f *filter) inBounds(value interface{}) bool {
+	number, ok := value.(float64)
+	if !ok {
+		return false
+	}
+	for _, bound := range f.bounds {
+		switch {
+		case f.operator == operatorGreater && !(number > bound),
+			f.operator == operatorGreaterOrEqual && !(number >= bound),
+			f.operator == operatorLess && !(number < bound),
+			f.operator == operatorLessOrEqual && !(number <= bound):
+			return false
 		}
 	}
-	return result
+
+	return true
 }
 
 func convertToString(val interface{}) string {
@@ -103,36 +530,154 @@ func Contains[T comparable](s []T, e T) bool {
 	return false
 }
 
+// path is a field path as used in query parameters. Dotted names step into
+// objects, brackets into arrays: wheels[0].size picks an element, wheels[all],
+// wheels[any] and wheels[none] quantify over the elements and tags[len] is the
+// length of an array. Arrays met elsewhere match when any element does, so
+// tags=sale finds "sale" among the tags. Brackets holding anything else are
+// part of the field name.
 type path struct {
+	steps []pathStep
+}
+
+type stepKind int
+
+const (
+	stepField stepKind = iota
+	stepIndex
+	stepAny
+	stepAll
+	stepNone
+	stepLen
+)
+
+var quantifiers = map[string]stepKind{"any": stepAny, "all": stepAll, "none": stepNone, "len": stepLen}
+
+type pathStep struct {
+	kind  stepKind
 	field string
-	next  *path
+	// index counts from the end when negative
+	index int
 }
 
-func (p *path) findValue(values map[string]interface{}) (string, bool) {
-	stepValue, found := values[p.field]
-	if !found {
-		return "", false
+// match reports whether the value at the path satisfies predicate.
+func (p *path) match(doc map[string]interface{}, predicate func(value interface{}) bool) bool {
+	return matchSteps(doc, p.steps, predicate)
+}
+
+func matchSteps(value interface{}, steps []pathStep, predicate func(value interface{}) bool) bool {
+	elements, isArray := value.([]interface{})
+	if len(steps) == 0 {
+		if isArray {
+			return anyElement(elements, steps, predicate)
+		}
+		return predicate(value)
 	}
 
-	if p.next == nil {
-		return convertToString(stepValue), true
+	step := steps[0]
+	if step.kind == stepField {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, found := v[step.field]
+			return found && matchSteps(child, steps[1:], predicate)
+		case []interface{}:
+			return anyElement(v, steps, predicate)
+		default:
+			return false
+		}
 	}
 
-	if stepValues, ok := stepValue.(map[string]interface{}); ok {
-		return p.next.findValue(stepValues)
+	if !isArray {
+		return false
 	}
+	switch step.kind {
+	case stepIndex:
+		index := step.index
+		if index < 0 {
+			index += len(elements)
+		}
+		return index >= 0 && index < len(elements) && matchSteps(elements[index], steps[1:], predicate)
+	case stepAny:
+		return anyElement(elements, steps[1:], predicate)
+	case stepAll:
+		for _, element := range elements {
+			if !matchSteps(element, steps[1:], predicate) {
+				return false
+			}
+		}
+		return len(elements) > 0
+	case stepNone:
+		return !anyElement(elements, steps[1:], predicate)
+	default:
+		return matchSteps(float64(len(elements)), steps[1:], predicate)
+	}
+}
 
-	return "", false
+func anyElement(elements []interface{}, steps []pathStep, predicate func(value interface{}) bool) bool {
+	for _, element := range elements {
+		if matchSteps(element, steps, predicate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// values returns every value the path reaches, with arrays flattened and
+// quantifiers read as any.
+func (p *path) values(doc map[string]interface{}) []interface{} {
+	var values []interface{}
+	p.match(doc, func(value interface{}) bool {
+		values = append(values, value)
+		return false
+	})
+
+	return values
+}
+
+// findValue renders the first value the path reaches.
+func (p *path) findValue(doc map[string]interface{}) (string, bool) {
+	values := p.values(doc)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return convertToString(values[0]), true
 }
 
 func buildPath(field string) path {
-	subFields := strings.Split(field, ".")
-	return *createPath(subFields[0], subFields[1:])
+	var p path
+	for _, part := range strings.Split(field, ".") {
+		var brackets []pathStep
+		for strings.HasSuffix(part, "]") {
+			open := strings.LastIndex(part, "[")
+			if open < 0 {
+				break
+			}
+			step, ok := parseBracket(part[open+1 : len(part)-1])
+			if !ok {
+				break
+			}
+			brackets = append([]pathStep{step}, brackets...)
+			part = part[:open]
+		}
+
+		if part != "" || len(brackets) == 0 {
+			p.steps = append(p.steps, pathStep{kind: stepField, field: part})
+		}
+		p.steps = append(p.steps, brackets...)
+	}
+
+	return p
 }
 
-func createPath(field string, rest []string) *path {
-	if len(rest) == 0 {
-		return &path{field: field}
+func parseBracket(content string) (pathStep, bool) {
+	if kind, found := quantifiers[content]; found {
+		return pathStep{kind: kind}, true
 	}
-	return &path{field: field, next: createPath(rest[0], rest[1:])}
+	if index, err := strconv.Atoi(content); err == nil {
+		return pathStep{kind: stepIndex, index: index}, true
+	}
+
+	return pathStep{}, false
 }
@@ -1,57 +1,113 @@
 package service
 
 import (
+	"context"
+
 	"github.com/gomodule/redigo/redis"
 )
 
 type JsonServiceImpl struct {
-	prefix    string
-	redisPool *redis.Pool
+	keys     KeyTemplate
+	backend  *RedisBackend
+	readFrom ReadFrom
+}
+
+func NewJsonService(keys KeyTemplate, backend *RedisBackend, readFrom ReadFrom) *JsonServiceImpl {
+	return &JsonServiceImpl{keys, backend, readFrom}
+}
+
+// Bind returns the service for the sub-keyspace selected by path parameters.
+func (s *JsonServiceImpl) Bind(params map[string]string) (*JsonServiceImpl, error) {
+	keys, err := s.keys.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonServiceImpl{keys, s.backend, s.readFrom}, nil
 }
 
-func NewJsonService(prefix string, redisPool *redis.Pool) *JsonServiceImpl {
-	return &JsonServiceImpl{prefix, redisPool}
+func (s *JsonServiceImpl) getConn(ctx context.Context) (redis.Conn, error) {
+	return s.backend.ReadConn(ctx, s.readFrom)
 }
 
-func (s *JsonServiceImpl) GetAll() ([]string, error) {
-	conn := s.redisPool.Get()
+func (s *JsonServiceImpl) GetAll(ctx context.Context) ([]string, error) {
+	_, result, err := s.getAll(ctx)
+	return result, err
+}
+
+// GetDocuments returns the whole keyspace parsed, every document with its id.
+func (s *JsonServiceImpl) GetDocuments(ctx context.Context) (*DocumentSet, error) {
+	keys, data, err := s.getAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := NewDocumentSet(data)
+	for n, key := range keys {
+		docs.Docs[n].Id, _ = s.keys.Id(key)
+	}
+	return docs, nil
+}
+
+func (s *JsonServiceImpl) getAll(ctx context.Context) ([]string, []string, error) {
+	conn, err := s.getConn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer func(conn redis.Conn) {
 		_ = conn.Close()
 	}(conn)
 
-	keys, err := s.GetAllKeys()
+	keys, err := s.getAllKeys(ctx, conn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	result := make([]string, 0, len(keys))
 	for _, key := range keys {
-		data, err := redis.String(conn.Do("GET", key))
+		data, err := redis.String(redis.DoContext(conn, ctx, "GET", key))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		result = append(result, data)
 	}
 
-	return result, nil
+	return keys, result, nil
+}
+
+func (s *JsonServiceImpl) CircuitOpen() bool {
+	return s.backend.CircuitOpen(s.readFrom)
 }
 
-func (s *JsonServiceImpl) GetPrefix() string {
-	return s.prefix
+// Pattern is the KEYS pattern of the documents the service reads.
+func (s *JsonServiceImpl) Pattern() string {
+	return s.keys.Pattern()
 }
 
-func (s *JsonServiceImpl) GetById(id string) (string, error) {
-	return s.GetByKey(s.prefix + id)
+func (s *JsonServiceImpl) Key(id string) string {
+	return s.keys.Key(id)
 }
 
-func (s *JsonServiceImpl) GetByKey(key string) (string, error) {
-	conn := s.redisPool.Get()
+// Id returns the id of the document stored under key, see KeyTemplate.Id.
+func (s *JsonServiceImpl) Id(key string) (string, bool) {
+	return s.keys.Id(key)
+}
+
+func (s *JsonServiceImpl) GetById(ctx context.Context, id string) (string, error) {
+	return s.GetByKey(ctx, s.keys.Key(id))
+}
+
+func (s *JsonServiceImpl) GetByKey(ctx context.Context, key string) (string, error) {
+	conn, err := s.getConn(ctx)
+	if err != nil {
+		return "", err
+	}
 	defer func(conn redis.Conn) {
 		_ = conn.Close()
 	}(conn)
 
-	result, err := conn.Do("GET", key)
+	result, err := redis.DoContext(conn, ctx, "GET", key)
 	if err != nil {
 		return "", err
 	}
@@ -68,13 +124,67 @@ func (s *JsonServiceImpl) GetByKey(key string) (string, error) {
 	return data, nil
 }
 
-func (s *JsonServiceImpl) GetAllKeys() ([]string, error) {
-	conn := s.redisPool.Get()
+// GetByIds reads all ids with a single MGET. Missing ids are "" and the order is kept.
+func (s *JsonServiceImpl) GetByIds(ctx context.Context, ids []string) ([]string, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.keys.Key(id))
+	}
+
+	return s.GetByKeys(ctx, keys)
+}
+
+func (s *JsonServiceImpl) GetByKeys(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return []string{}, nil
+	}
+
+	conn, err := s.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer func(conn redis.Conn) {
 		_ = conn.Close()
 	}(conn)
 
-	keys, err := redis.Strings(conn.Do("KEYS", s.prefix+"*"))
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	values, err := redis.Values(redis.DoContext(conn, ctx, "MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if result[i], err = redis.String(value, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (s *JsonServiceImpl) GetAllKeys(ctx context.Context) ([]string, error) {
+	conn, err := s.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func(conn redis.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	return s.getAllKeys(ctx, conn)
+}
+
+func (s *JsonServiceImpl) getAllKeys(ctx context.Context, conn redis.Conn) ([]string, error) {
+	keys, err := redis.Strings(redis.DoContext(conn, ctx, "KEYS", s.Pattern()))
 	if err != nil {
 		return nil, err
 	}
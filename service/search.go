@@ -0,0 +1,163 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unsafe"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ParamSearch carries free text searched in the prefix's search fields, e.g. ?_q=red convertible.
+// Every word has to occur in one of them, results are sorted by relevance.
+const ParamSearch = "_q"
+
+// searchField is a configured search field, "name^2" weighs name twice.
+type searchField struct {
+	fieldPath path
+	weight    float64
+}
+
+func parseSearchFields(specs []string) []searchField {
+	fields := make([]searchField, 0, len(specs))
+	for _, spec := range specs {
+		field, weightText, _ := strings.Cut(spec, "^")
+		weight, err := strconv.ParseFloat(weightText, 64)
+		if err != nil || weight <= 0 {
+			weight = 1
+		}
+		fields = append(fields, searchField{fieldPath: buildPath(field), weight: weight})
+	}
+
+	return fields
+}
+
+// searchTokens splits text into words, lower-cased and without diacritics, so
+// that "Crème Brûlée" is found by "creme brulee".
+func searchTokens(text string) []string {
+	folded := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, norm.NFD.String(text))
+
+	return strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchIndex is an inverted index over the search fields of a DocumentSet.
+type searchIndex struct {
+	fields []searchField
+	// postings lists per word the documents and fields it occurs in, by position
+	postings map[string][]searchPosting
+	count    int
+}
+
+type searchPosting struct {
+	position  int
+	field     int
+	frequency int
+}
+
+func buildSearchIndex(fields []searchField, docs []Document) *searchIndex {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	index := &searchIndex{fields: fields, postings: make(map[string][]searchPosting), count: len(docs)}
+	for position, doc := range docs {
+		if doc.Value == nil {
+			continue
+		}
+
+		for n, field := range fields {
+			frequencies := make(map[string]int)
+			for _, value := range field.fieldPath.values(doc.Value) {
+				if text, ok := value.(string); ok {
+					for _, token := range searchTokens(text) {
+						frequencies[token]++
+					}
+				}
+			}
+			for token, frequency := range frequencies {
+				index.postings[token] = append(index.postings[token], searchPosting{position: position, field: n, frequency: frequency})
+			}
+		}
+	}
+
+	return index
+}
+
+// cost estimates the memory of the postings, see DocumentSet.cost.
+func (i *searchIndex) cost() int64 {
+	cost := int64(mapCost)
+	for word, postings := range i.postings {
+		cost += mapEntryCost + int64(len(word)) + sliceCost + int64(len(postings))*int64(unsafe.Sizeof(searchPosting{}))
+	}
+
+	return cost
+}
+
+// score returns the relevance of every document containing all terms, by
+// position. Each word adds a saturating term frequency per field, scaled by the
+// field's weight and by how rare the word is across the set.
+func (i *searchIndex) score(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	for n, term := range terms {
+		postings := i.postings[term]
+
+		documents := make(map[int]bool, len(postings))
+		for _, posting := range postings {
+			documents[posting.position] = true
+		}
+		rarity := math.Log(1 + (float64(i.count)-float64(len(documents))+0.5)/(float64(len(documents))+0.5))
+
+		termScores := make(map[int]float64, len(documents))
+		for _, posting := range postings {
+			frequency := float64(posting.frequency)
+			termScores[posting.position] += i.fields[posting.field].weight * rarity * frequency * 2.2 / (frequency + 1.2)
+		}
+
+		// a document has to contain every term, so the first one decides the candidates
+		next := make(map[int]float64, len(termScores))
+		for position, termScore := range termScores {
+			if previous, found := scores[position]; found || n == 0 {
+				next[position] = previous + termScore
+			}
+		}
+		scores = next
+	}
+
+	return scores
+}
+
+// searchTerms reads the distinct words of all _q values.
+func searchTerms(values []string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, token := range searchTokens(value) {
+			if !seen[token] {
+				seen[token] = true
+				terms = append(terms, token)
+			}
+		}
+	}
+
+	return terms
+}
+
+func sortedPositions(scores map[int]float64) []int {
+	positions := make([]int, 0, len(scores))
+	for position := range scores {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+
+	return positions
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+func (suite *IntegrationTestSuite) putAggregatedCars(prefix string) {
+	suite.PutToRedisAsJson(prefix+"1", map[string]interface{}{"brand": "BMW", "price": 30000, "year": 2019, "owner": map[string]interface{}{"city": "Berlin"}})
+	suite.PutToRedisAsJson(prefix+"2", map[string]interface{}{"brand": "BMW", "price": 50000, "year": 2023, "owner": map[string]interface{}{"city": "Munich"}})
+	suite.PutToRedisAsJson(prefix+"3", map[string]interface{}{"brand": "Audi", "price": 40000, "year": 2021, "owner": map[string]interface{}{"city": "Berlin"}})
+	suite.PutToRedisAsJson(prefix+"4", map[string]interface{}{"brand": nil, "price": 10000, "year": 2010})
+}
+
+func (suite *IntegrationTestSuite) TestAggregateGroupBy() {
+	// given
+	suite.putAggregatedCars("cars.")
+
+	// when
+	var result []map[string]interface{}
+	suite.HttpGetJson("/cars/_aggregate?_groupBy=brand&_metrics=count,avg(price),max(year)", &result)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{
+		{"brand": nil, "count": 1.0, "avg(price)": 10000.0, "max(year)": 2010.0},
+		{"brand": "Audi", "count": 1.0, "avg(price)": 40000.0, "max(year)": 2021.0},
+		{"brand": "BMW", "count": 2.0, "avg(price)": 40000.0, "max(year)": 2023.0},
+	}, result)
+}
+
+func (suite *IntegrationTestSuite) TestAggregateWithoutGroupByAfterFilters() {
+	// given
+	suite.putAggregatedCars("cars.")
+
+	// when
+	var result map[string]interface{}
+	suite.HttpGetJson("/cars/_aggregate?year[gte]=2020&_metrics=count,sum(price),min(year)", &result)
+
+	// then
+	assert.Equal(suite.T(), map[string]interface{}{"count": 2.0, "sum(price)": 90000.0, "min(year)": 2021.0}, result)
+}
+
+func (suite *IntegrationTestSuite) TestAggregateNestedGroupByOnSnapshot() {
+	// given
+	suite.putAggregatedCars("cached-cars.")
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []map[string]interface{}
+	suite.HttpGetJson("/cached-cars/_aggregate?_groupBy=owner.city,brand", &result)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{
+		{"owner.city": nil, "brand": nil, "count": 1.0},
+		{"owner.city": "Berlin", "brand": "Audi", "count": 1.0},
+		{"owner.city": "Berlin", "brand": "BMW", "count": 1.0},
+		{"owner.city": "Munich", "brand": "BMW", "count": 1.0},
+	}, result)
+}
+
+func (suite *IntegrationTestSuite) TestAggregateEmpty() {
+	// when
+	var result map[string]interface{}
+	suite.HttpGetJson("/cars/_aggregate?_metrics=count,avg(price)", &result)
+
+	// then
+	assert.Equal(suite.T(), map[string]interface{}{"count": 0.0, "avg(price)": nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestAggregateUnknownMetric() {
+	// when
+	response := suite.HttpGet("/cars/_aggregate?_metrics=median(price)")
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestAggregateRejectsOtherReservedParameters() {
+	// given
+	suite.putAggregatedCars("cars.")
+
+	// when
+	expand := suite.HttpGet("/cars/_aggregate?_expand=owner")
+	_ = expand.Body.Close()
+	ids := suite.HttpGet("/cars/_aggregate?_ids=1,2")
+	_ = ids.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, expand.StatusCode)
+	assert.Equal(suite.T(), http.StatusBadRequest, ids.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestGetAllRejectsAggregateParameters() {
+	// given
+	suite.putAggregatedCars("cars.")
+
+	// when
+	response := suite.HttpGet("/cars?_groupBy=brand")
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+)
+
+type Wheel struct {
+	Position string `json:"position"`
+	Size     int    `json:"size"`
+}
+
+type TaggedCar struct {
+	ID     string   `json:"id"`
+	Tags   []string `json:"tags"`
+	Wheels []Wheel  `json:"wheels"`
+}
+
+func (suite *IntegrationTestSuite) putTaggedCars() (TaggedCar, TaggedCar, TaggedCar) {
+	car1 := TaggedCar{ID: "1", Tags: []string{"sale", "new"}, Wheels: []Wheel{{"front", 17}, {"rear", 18}}}
+	car2 := TaggedCar{ID: "2", Tags: []string{"used"}, Wheels: []Wheel{{"front", 17}, {"rear", 17}}}
+	car3 := TaggedCar{ID: "3", Tags: []string{}, Wheels: []Wheel{{"front", 16}, {"rear", 16}}}
+	suite.PutToRedisAsJson("query.1", car1)
+	suite.PutToRedisAsJson("query.2", car2)
+	suite.PutToRedisAsJson("query.3", car3)
+
+	return car1, car2, car3
+}
+
+func (suite *IntegrationTestSuite) TestArrayPathMatchesAnyElement() {
+	// given
+	car1, car2, _ := suite.putTaggedCars()
+
+	// when
+	var tagged []TaggedCar
+	suite.HttpGetJson("/query?tags=sale", &tagged)
+	var withSize []TaggedCar
+	suite.HttpGetJson("/query?wheels.size=17", &withSize)
+
+	// then
+	assert.Equal(suite.T(), []TaggedCar{car1}, tagged)
+	assert.ElementsMatch(suite.T(), []TaggedCar{car1, car2}, withSize)
+}
+
+func (suite *IntegrationTestSuite) TestArrayPathIndex() {
+	// given
+	car1, _, _ := suite.putTaggedCars()
+
+	// when
+	var result []TaggedCar
+	suite.HttpGetJson("/query?wheels[1].size=18", &result)
+	var last []TaggedCar
+	suite.HttpGetJson("/query?tags[-1]=new", &last)
+
+	// then
+	assert.Equal(suite.T(), []TaggedCar{car1}, result)
+	assert.Equal(suite.T(), []TaggedCar{car1}, last)
+}
+
+func (suite *IntegrationTestSuite) TestArrayPathQuantifiers() {
+	// given
+	car1, car2, car3 := suite.putTaggedCars()
+
+	// when
+	var all []TaggedCar
+	suite.HttpGetJson("/query?wheels[all].size=17", &all)
+	var none []TaggedCar
+	suite.HttpGetJson("/query?wheels[none].size[gte]=17", &none)
+	var anyOf []TaggedCar
+	suite.HttpGetJson("/query?wheels[any].size[gt]=17", &anyOf)
+	var noTags []TaggedCar
+	suite.HttpGetJson("/query?tags[all]=sale", &noTags)
+
+	// then
+	assert.Equal(suite.T(), []TaggedCar{car2}, all)
+	assert.Equal(suite.T(), []TaggedCar{car3}, none)
+	assert.Equal(suite.T(), []TaggedCar{car1}, anyOf)
+	assert.Empty(suite.T(), noTags, "all needs at least one element")
+}
+
+func (suite *IntegrationTestSuite) TestArrayPathLength() {
+	// given
+	car1, car2, car3 := suite.putTaggedCars()
+
+	// when
+	var several []TaggedCar
+	suite.HttpGetJson("/query?tags[len][gte]=2", &several)
+	var empty []TaggedCar
+	suite.HttpGetJson("/query?tags[len]=0", &empty)
+	var upToOne []TaggedCar
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`tags[len] <= 1`), &upToOne)
+
+	// then
+	assert.Equal(suite.T(), []TaggedCar{car1}, several)
+	assert.Equal(suite.T(), []TaggedCar{car3}, empty)
+	assert.ElementsMatch(suite.T(), []TaggedCar{car2, car3}, upToOne)
+}
+
+func (suite *IntegrationTestSuite) TestArrayPathInFilterExpression() {
+	// given
+	_, car2, car3 := suite.putTaggedCars()
+
+	// when
+	var result []TaggedCar
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`tags != "sale" AND wheels[0].position = "front"`), &result)
+
+	// then
+	assert.ElementsMatch(suite.T(), []TaggedCar{car2, car3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestIndexedArrayField() {
+	// given
+	car1 := TaggedCar{ID: "1", Tags: []string{"sale", "sale", "new"}}
+	car2 := TaggedCar{ID: "2", Tags: []string{"used"}}
+	suite.PutToRedisAsJson("indexed-cars.1", car1)
+	suite.PutToRedisAsJson("indexed-cars.2", car2)
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []TaggedCar
+	suite.HttpGetJson("/indexed-cars?tags=sale&tags=new", &result)
+
+	// then
+	assert.Equal(suite.T(), []TaggedCar{car1}, result, "a document holding several matching values is listed once")
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+)
+
+type HealthResponse struct {
+	Backends []struct {
+		Name    string
+		Healthy bool
+		Nodes   []struct {
+			Name    string
+			Healthy bool
+		}
+	}
+}
+
+func (suite *IntegrationTestSuite) TestNamedBackendGetByIdFound() {
+	// given
+	original := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("secondary-cars.1", original)
+
+	// when
+	var result Car
+	suite.HttpGetJson("/secondary-cars/1", &result)
+
+	// then
+	assert.Equal(suite.T(), original, result)
+}
+
+func (suite *IntegrationTestSuite) TestHealthReportsEveryBackend() {
+	// when
+	response := suite.HttpGet("/_admin/health")
+	var health HealthResponse
+	err := json.NewDecoder(response.Body).Decode(&health)
+	_ = response.Body.Close()
+
+	// then
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Equal(suite.T(), 4, len(health.Backends))
+	assert.Equal(suite.T(), "default", health.Backends[0].Name)
+	assert.Equal(suite.T(), 3, len(health.Backends[0].Nodes))
+	assert.True(suite.T(), health.Backends[0].Nodes[1].Healthy)
+	assert.False(suite.T(), health.Backends[0].Nodes[2].Healthy)
+	assert.Equal(suite.T(), "flaky", health.Backends[1].Name)
+	assert.Equal(suite.T(), "secondary", health.Backends[2].Name)
+	assert.True(suite.T(), health.Backends[2].Healthy)
+	assert.Equal(suite.T(), "slow", health.Backends[3].Name)
+}
+
+func (suite *IntegrationTestSuite) TestMetricsArePerBackend() {
+	// given
+	suite.PutToRedisAsJson("secondary-cars.1", Car{ID: "1"})
+	suite.HttpGet("/secondary-cars/1")
+
+	// when
+	response := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Contains(suite.T(), string(body), `redis_commands_total{backend="secondary",node="primary"}`)
+	assert.Contains(suite.T(), string(body), `redis_node_up{backend="default",node="replica-1"} 0`)
+}
+
+func (suite *IntegrationTestSuite) TestMetricsDeclareTheirTypes() {
+	// when
+	response := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	assert.Contains(suite.T(), string(body), "# TYPE redis_commands_total counter\nredis_commands_total{")
+	assert.Contains(suite.T(), string(body), "# TYPE redis_node_up gauge\nredis_node_up{")
+}
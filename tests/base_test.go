@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,10 +11,12 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	rt "github.com/testcontainers/testcontainers-go/modules/redis"
+	"io"
 	"net/http"
 	. "redis-go-dispatcher/config"
 	"redis-go-dispatcher/server"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,6 +26,8 @@ type IntegrationTestSuite struct {
 	URLPrefix      string
 	RedisPool      *redis.Pool
 	RedisContainer *rt.RedisContainer
+	FlakyProxy     *TcpProxy
+	SlowProxy      *TcpProxy
 }
 
 var cacheDuration = 100 * time.Millisecond
@@ -32,6 +37,7 @@ func testPrefixes() []Prefix {
 		{
 			URI:                  "/cached-cars",
 			RedisPrefix:          "cached-cars.",
+			SearchFields:         []string{"name^2", "description"},
 			CacheEnabled:         true,
 			CacheRefreshDuration: cacheDuration,
 			CacheTtl:             cacheDuration,
@@ -65,18 +71,205 @@ func testPrefixes() []Prefix {
 			CacheEnabled:         true,
 			CacheRefreshDuration: cacheDuration,
 			CacheTtl:             cacheDuration,
+		}, {
+			URI:                  "/cached-generation",
+			RedisPrefix:          "cached-generation.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             10 * cacheDuration,
+		}, {
+			URI:                  "/read-through-cars",
+			RedisPrefix:          "read-through-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: 10 * time.Second,
+			CacheTtl:             cacheDuration,
+			CacheMode:            CacheModeReadThrough,
+		}, {
+			URI:                  "/swr-cars",
+			RedisPrefix:          "swr-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: 10 * time.Second,
+			CacheTtl:             cacheDuration,
+			CacheMode:            CacheModeStaleWhileRevalidate,
+		}, {
+			URI:              "/micro-cars",
+			RedisPrefix:      "micro-cars.",
+			CacheEnabled:     false,
+			CoalesceQueries:  true,
+			MicroCacheWindow: 2 * cacheDuration,
+		}, {
+			URI:                  "/budget-cars",
+			RedisPrefix:          "budget-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			CacheMaxItems:        2,
+			CacheMaxBytes:        1 << 10,
+		}, {
+			URI:                  "/parsed-budget-cars",
+			RedisPrefix:          "parsed-budget-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			CacheMaxBytes:        512,
+		}, {
+			URI:                  "/indexed-cars",
+			RedisPrefix:          "indexed-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			Indexes:              []string{"Model", "Year", "tags"},
+		}, {
+			URI:                  "/conditional-cars",
+			RedisPrefix:          "conditional-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			CacheControl:         CacheControl{MaxAge: time.Minute, StaleWhileRevalidate: 30 * time.Second},
+		}, {
+			URI:                  "/compressed-cars",
+			RedisPrefix:          "compressed-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			CompressionMinSize:   64,
+		}, {
+			URI:          "/owned-cars",
+			RedisPrefix:  "owned-cars.",
+			CacheEnabled: false,
+			Relations: map[string]Relation{
+				"owner": {Field: "OwnerID", Prefix: "/owners"},
+			},
+		}, {
+			URI:          "/tenants/:tenant/orders",
+			RedisKey:     "tenant:{tenant}:order:{id}",
+			CacheEnabled: false,
+		}, {
+			URI:          "/tenants_tenant/orders",
+			RedisPrefix:  "legacy-orders.",
+			CacheEnabled: false,
+			Schema:       "testdata/order.schema.json",
+		}, {
+			URI:          "/owners",
+			RedisPrefix:  "owners.",
+			CacheEnabled: false,
+			Relations: map[string]Relation{
+				"cars": {Field: "CarIDs", Prefix: "/owned-cars"},
+			},
+		}, {
+			URI:          "/replica-cars",
+			RedisPrefix:  "replica-cars.",
+			CacheEnabled: false,
+			ReadFrom:     ReadFromReplica,
+		}, {
+			URI:          "/primary-cars",
+			RedisPrefix:  "primary-cars.",
+			CacheEnabled: false,
+			ReadFrom:     ReadFromPrimary,
+		}, {
+			URI:          "/secondary-cars",
+			RedisPrefix:  "secondary-cars.",
+			CacheEnabled: false,
+			Redis:        "secondary",
+		}, {
+			URI:            "/timeout-cars",
+			RedisPrefix:    "timeout-cars.",
+			CacheEnabled:   false,
+			RequestTimeout: time.Nanosecond,
+		}, {
+			URI:            "/bounded-cars",
+			RedisPrefix:    "bounded-cars.",
+			CacheEnabled:   false,
+			RequestTimeout: 5 * time.Second,
+		}, {
+			URI:                  "/flaky-cached-cars",
+			RedisPrefix:          "flaky-cached-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			Redis:                "flaky",
+		}, {
+			URI:          "/flaky-cars",
+			RedisPrefix:  "flaky-cars.",
+			CacheEnabled: false,
+			Redis:        "flaky",
+		}, {
+			URI:            "/slow-cars",
+			RedisPrefix:    "slow-cars.",
+			CacheEnabled:   false,
+			Redis:          "slow",
+			RequestTimeout: 200 * time.Millisecond,
 		}, {
 			URI:          "/cars",
 			RedisPrefix:  "cars.",
 			CacheEnabled: false,
+			SearchFields: []string{"name^2", "description"},
+			Schema:       "testdata/car.schema.json",
+			SchemaPolicy: SchemaPolicyPass,
+		}, {
+			URI:          "/checked-cars",
+			RedisPrefix:  "checked-cars.",
+			Schema:       "testdata/car.schema.json",
+			SchemaPolicy: SchemaPolicyDrop,
+			Relations: map[string]Relation{
+				"predecessor": {Field: "PredecessorID", Prefix: "/checked-cars"},
+			},
+		}, {
+			URI:          "/annotated-cars",
+			RedisPrefix:  "annotated-cars.",
+			Schema:       "testdata/car.schema.json",
+			SchemaPolicy: SchemaPolicyAnnotate,
 		}, {
 			URI:          "/people",
 			RedisPrefix:  "people.",
 			CacheEnabled: false,
+		}, {
+			URI:          "/graph-cars",
+			RedisPrefix:  "graph-cars.",
+			SearchFields: []string{"Model"},
+			Schema:       "testdata/graph-car.schema.json",
+			Relations: map[string]Relation{
+				"owner": {Field: "OwnerID", Prefix: "/graph-people"},
+			},
+		}, {
+			URI:         "/graph-people",
+			RedisPrefix: "graph-people.",
+			Schema:      "testdata/person.schema.json",
+			Relations: map[string]Relation{
+				"cars": {Field: "CarIDs", Prefix: "/graph-cars"},
+			},
 		}, {
 			URI:          "/query",
 			RedisPrefix:  "query.",
 			CacheEnabled: false,
+		}, {
+			URI:         "/skipping-query",
+			RedisPrefix: "skipping-query.",
+			InvalidJson: InvalidJsonSkip,
+		}, {
+			URI:         "/lenient-query",
+			RedisPrefix: "lenient-query.",
+			InvalidJson: InvalidJsonInclude,
+		}, {
+			URI:         "/strict-query",
+			RedisPrefix: "strict-query.",
+			InvalidJson: InvalidJsonFail,
+		}, {
+			URI:                  "/strict-indexed-cars",
+			RedisPrefix:          "strict-indexed-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			Indexes:              []string{"Model"},
+			InvalidJson:          InvalidJsonFail,
+		}, {
+			URI:                  "/lenient-searched-cars",
+			RedisPrefix:          "lenient-searched-cars.",
+			CacheEnabled:         true,
+			CacheRefreshDuration: cacheDuration,
+			CacheTtl:             cacheDuration,
+			SearchFields:         []string{"Model"},
+			InvalidJson:          InvalidJsonInclude,
 		}, {
 			URI:          "/complex-query",
 			RedisPrefix:  "complex-query.",
@@ -102,6 +295,8 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 
 func (suite *IntegrationTestSuite) TearDownSuite() {
 	_ = suite.RedisPool.Close()
+	suite.FlakyProxy.Close()
+	suite.SlowProxy.Close()
 	_ = suite.RedisContainer.Terminate(context.Background())
 }
 
@@ -115,6 +310,8 @@ func (suite *IntegrationTestSuite) TearDownTest() {
 }
 
 func (suite *IntegrationTestSuite) startWebServer(connectionString string) {
+	suite.FlakyProxy = StartTcpProxy(connectionString)
+	suite.SlowProxy = StartSlowTcpProxy(connectionString, 20*time.Millisecond)
 	port := getFreePort()
 	go server.StartServer(Config{
 		Prefixes: testPrefixes(),
@@ -122,7 +319,33 @@ func (suite *IntegrationTestSuite) startWebServer(connectionString string) {
 			URL:           connectionString,
 			PoolMaxIdle:   5,
 			PoolMaxActive: 10,
+			// the second replica is never reachable and must be skipped by health checks
+			Replicas: []string{connectionString, "redis://localhost:1"},
 		},
+		RedisBackends: map[string]RedisConfig{
+			"secondary": {
+				URL:           connectionString,
+				PoolMaxIdle:   2,
+				PoolMaxActive: 5,
+			},
+			"flaky": {
+				URL:                 suite.FlakyProxy.URL(),
+				PoolMaxIdle:         2,
+				PoolMaxActive:       5,
+				HealthCheckInterval: cacheDuration,
+				CircuitBreaker: CircuitBreakerConfig{
+					FailureThreshold: 1,
+					OpenTimeout:      2 * cacheDuration,
+				},
+			},
+			"slow": {
+				URL:            suite.SlowProxy.URL(),
+				PoolMaxIdle:    2,
+				PoolMaxActive:  5,
+				CircuitBreaker: CircuitBreakerConfig{Disabled: true},
+			},
+		},
+		GraphQL:    GraphQLConfig{MaxDepth: 6, MaxComplexity: 2000, InferRefresh: 100 * time.Millisecond},
 		ServerPort: port,
 	})
 
@@ -175,6 +398,15 @@ func (suite *IntegrationTestSuite) PutToRedisAsJson(key string, obj interface{})
 	_, _ = conn.Do("SET", key, value)
 }
 
+func (suite *IntegrationTestSuite) PutToRedis(key string, value string) {
+	conn := suite.RedisPool.Get()
+	defer func(conn redis.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	_, _ = conn.Do("SET", key, value)
+}
+
 func (suite *IntegrationTestSuite) DeleteFromRedis(key string) {
 	conn := suite.RedisPool.Get()
 	defer func(conn redis.Conn) {
@@ -194,8 +426,52 @@ func (suite *IntegrationTestSuite) HttpGetJson(uri string, target interface{}) {
 	assert.NoError(suite.T(), err)
 }
 
+func (suite *IntegrationTestSuite) DecodeJson(resp *http.Response, target interface{}) {
+	err := json.NewDecoder(resp.Body).Decode(target)
+	_ = resp.Body.Close()
+	assert.NoError(suite.T(), err)
+}
+
 func (suite *IntegrationTestSuite) HttpGet(uri string) *http.Response {
 	resp, err := http.Get(suite.URLPrefix + uri)
 	assert.NoError(suite.T(), err)
 	return resp
 }
+
+func (suite *IntegrationTestSuite) HttpPostJson(uri string, body interface{}) *http.Response {
+	payload, err := json.Marshal(body)
+	assert.NoError(suite.T(), err)
+
+	resp, err := http.Post(suite.URLPrefix+uri, "application/json", bytes.NewReader(payload))
+	assert.NoError(suite.T(), err)
+	return resp
+}
+
+func (suite *IntegrationTestSuite) HttpGetWithHeaders(uri string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, suite.URLPrefix+uri, nil)
+	assert.NoError(suite.T(), err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(suite.T(), err)
+	return resp
+}
+
+// MetricValue reads one series from /_admin/metrics, 0 when it is not there yet.
+func (suite *IntegrationTestSuite) MetricValue(series string) float64 {
+	response := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, found := strings.CutPrefix(line, series+" "); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			assert.NoError(suite.T(), err)
+			return parsed
+		}
+	}
+
+	return 0
+}
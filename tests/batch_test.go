@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+)
+
+func (suite *IntegrationTestSuite) TestBatchGetIdsKeepsOrder() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := Car{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("cars.1", car1)
+	suite.PutToRedisAsJson("cars.2", car2)
+
+	// when
+	var result []*Car
+	suite.HttpGetJson("/cars?_ids=2,missing,1", &result)
+
+	// then
+	assert.Equal(suite.T(), []*Car{&car2, nil, &car1}, result)
+}
+
+func (suite *IntegrationTestSuite) TestBatchPost() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("cars.1", car1)
+
+	// when
+	response := suite.HttpPostJson("/cars/_batch", []interface{}{"1", 3})
+
+	// then
+	var result []*Car
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	suite.DecodeJson(response, &result)
+	assert.Equal(suite.T(), []*Car{&car1, nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestBatchPostInvalidBody() {
+	// when
+	response := suite.HttpPostJson("/cars/_batch", map[string]string{"id": "1"})
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestBatchPostTooManyIds() {
+	// given
+	ids := make([]int, 1001)
+
+	// when
+	response := suite.HttpPostJson("/cars/_batch", ids)
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestBatchPostBodyTooLarge() {
+	// when
+	response := suite.HttpPostJson("/cars/_batch", []string{strings.Repeat("1", 2<<20)})
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestBatchFromSnapshot() {
+	// given
+	car1 := CachedCar{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("cached-cars.1", car1)
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []*CachedCar
+	suite.HttpGetJson("/cached-cars?_ids=1,2", &result)
+
+	// then
+	assert.Equal(suite.T(), []*CachedCar{&car1, nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestBatchReadThroughLoadsMisses() {
+	// given
+	car1 := CachedCar{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("read-through-cars.1", car1)
+	var warmUp []CachedCar
+	suite.HttpGetJson("/read-through-cars", &warmUp)
+	car2 := CachedCar{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("read-through-cars.2", car2)
+
+	// when
+	var result []*CachedCar
+	suite.HttpGetJson("/read-through-cars?_ids=2,1,3", &result)
+
+	// then
+	assert.Equal(suite.T(), []*CachedCar{&car2, &car1, nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestBatchWithFiltersRejected() {
+	// when
+	response := suite.HttpGet("/cars?_ids=1&Model=Toyota")
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+)
+
+func (suite *IntegrationTestSuite) TestCacheBudgetFitsSnapshot() {
+	// given
+	car1 := CachedCar{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := CachedCar{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("budget-cars.1", car1)
+	suite.PutToRedisAsJson("budget-cars.2", car2)
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/budget-cars", &result)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car1, car2}, result)
+}
+
+func (suite *IntegrationTestSuite) TestCacheBudgetExceededRejectsSnapshot() {
+	// given
+	suite.PutToRedisAsJson("budget-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.PutToRedisAsJson("budget-cars.2", CachedCar{ID: "2", Model: "Honda", Year: 2023})
+	suite.PutToRedisAsJson("budget-cars.3", CachedCar{ID: "3", Model: "Mazda", Year: 2024})
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/budget-cars", &result)
+
+	response := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), 0, len(result))
+	assert.Regexp(suite.T(), `cache_snapshot_rejections_total\{prefix="/budget-cars"\} [1-9]`, string(body))
+}
+
+func (suite *IntegrationTestSuite) TestCacheBudgetChargesParsedDocuments() {
+	// given
+	fields := make([]string, 0, 30)
+	for n := 0; n < 30; n++ {
+		fields = append(fields, fmt.Sprintf(`"f%d":%d`, n, n))
+	}
+	document := "{" + strings.Join(fields, ",") + "}"
+	suite.PutToRedis("parsed-budget-cars.1", document)
+	suite.WaitForCacheDuration()
+
+	// when
+	response := suite.HttpGet("/parsed-budget-cars")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	assert.Less(suite.T(), len(document), 512)
+	assert.NotContains(suite.T(), string(body), `"f0"`)
+	assert.Greater(suite.T(), suite.MetricValue(`cache_snapshot_rejections_total{prefix="/parsed-budget-cars"}`), float64(0))
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"time"
+)
+
+func (suite *IntegrationTestSuite) TestReadThroughGetByIdCacheIsNotWarmedUpFound() {
+	// given
+	original := CachedCar{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("read-through-cars.1", original)
+
+	// when
+	var result CachedCar
+	suite.HttpGetJson("/read-through-cars/1", &result)
+
+	// then
+	assert.Equal(suite.T(), original, result)
+}
+
+func (suite *IntegrationTestSuite) TestReadThroughGetByIdNotFoundIsCachedNegatively() {
+	// given
+	response := suite.HttpGet("/read-through-cars/2")
+	assert.Equal(suite.T(), http.StatusNotFound, response.StatusCode)
+
+	// when
+	original := CachedCar{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("read-through-cars.2", original)
+	response = suite.HttpGet("/read-through-cars/2")
+
+	// then
+	assert.Equal(suite.T(), http.StatusNotFound, response.StatusCode)
+
+	suite.WaitForCacheDuration()
+	var result CachedCar
+	suite.HttpGetJson("/read-through-cars/2", &result)
+	assert.Equal(suite.T(), original, result)
+}
+
+func (suite *IntegrationTestSuite) TestReadThroughGetAllCacheIsNotWarmedUpFound() {
+	// given
+	car1 := CachedCar{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := CachedCar{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("read-through-cars.1", car1)
+	suite.PutToRedisAsJson("read-through-cars.2", car2)
+
+	// when
+	var result []CachedCar
+	// a refresh tick may have left an empty snapshot behind for one ttl
+	assert.Eventually(suite.T(), func() bool {
+		suite.HttpGetJson("/read-through-cars", &result)
+		return len(result) > 0
+	}, 2*cacheDuration, cacheDuration/10)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car1, car2}, result)
+}
+
+func (suite *IntegrationTestSuite) TestStaleWhileRevalidateServesExpiredEntryAndRefreshes() {
+	// given
+	original := CachedCar{ID: "10", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("swr-cars.10", original)
+
+	var result CachedCar
+	suite.HttpGetJson("/swr-cars/10", &result)
+	assert.Equal(suite.T(), original, result)
+	// let a refresh of an already expired snapshot finish before changing the data
+	time.Sleep(cacheDuration / 2)
+
+	// when
+	updated := CachedCar{ID: "10", Model: "Toyota", Year: 2024}
+	suite.PutToRedisAsJson("swr-cars.10", updated)
+	suite.WaitForCacheDuration()
+
+	response := suite.HttpGet("/swr-cars/10")
+	var stale CachedCar
+	suite.DecodeJson(response, &stale)
+
+	// then
+	assert.Equal(suite.T(), original, stale)
+	assert.Equal(suite.T(), "STALE", response.Header.Get("X-Cache"))
+
+	assert.Eventually(suite.T(), func() bool {
+		var fresh CachedCar
+		suite.HttpGetJson("/swr-cars/10", &fresh)
+		return fresh == updated
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,6 +2,8 @@ package tests
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strconv"
+	"time"
 )
 
 type CachedCar struct {
@@ -220,3 +222,43 @@ func (suite *IntegrationTestSuite) TestCachedGetAllMultiplePrefixesNoElementsRet
 	assert.Equal(suite.T(), 0, len(resultCars))
 	assert.Equal(suite.T(), 0, len(resultPeople))
 }
+
+func (suite *IntegrationTestSuite) TestCachedGetAllExposesSnapshotGeneration() {
+	// given
+	suite.PutToRedisAsJson("cached-generation.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.WaitForCacheDuration()
+
+	// when
+	first := suite.HttpGet("/cached-generation")
+	_ = first.Body.Close()
+	suite.WaitForCacheDuration()
+	second := suite.HttpGet("/cached-generation/1")
+	_ = second.Body.Close()
+
+	// then
+	firstGeneration, err := strconv.Atoi(first.Header.Get("X-Cache-Generation"))
+	assert.NoError(suite.T(), err)
+	secondGeneration, err := strconv.Atoi(second.Header.Get("X-Cache-Generation"))
+	assert.NoError(suite.T(), err)
+	assert.Greater(suite.T(), secondGeneration, firstGeneration)
+
+	builtAt, err := time.Parse(time.RFC3339Nano, second.Header.Get("X-Cache-Built-At"))
+	assert.NoError(suite.T(), err)
+	assert.WithinDuration(suite.T(), time.Now(), builtAt, time.Second)
+}
+
+func (suite *IntegrationTestSuite) TestCachedGetAllReadsSeveralChunks() {
+	// given
+	for n := 0; n < 1200; n++ {
+		suite.PutToRedisAsJson("cached-cars."+strconv.Itoa(n), CachedCar{ID: strconv.Itoa(n), Model: "Toyota", Year: 2022})
+	}
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/cached-cars", &result)
+
+	// then
+	assert.Equal(suite.T(), 1200, len(result))
+	assert.Equal(suite.T(), "0", result[0].ID)
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"time"
+)
+
+func (suite *IntegrationTestSuite) TestCircuitOpenServesStaleCacheAndFailsFastUncached() {
+	// given
+	original := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("flaky-cached-cars.1", original)
+	suite.PutToRedisAsJson("flaky-cars.1", original)
+	suite.WaitForCacheDuration()
+
+	response := suite.HttpGet("/flaky-cached-cars/1")
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Empty(suite.T(), response.Header.Get("X-Cache"))
+
+	defer suite.restoreFlakyBackend()
+
+	// when
+	suite.FlakyProxy.Disable()
+	suite.WaitForCacheDuration()
+	suite.WaitForCacheDuration()
+
+	// then
+	var one Car
+	response = suite.HttpGet("/flaky-cached-cars/1")
+	suite.DecodeJson(response, &one)
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Equal(suite.T(), "STALE", response.Header.Get("X-Cache"))
+	assert.Contains(suite.T(), response.Header.Get("Warning"), "110")
+	assert.Equal(suite.T(), original, one)
+
+	var all []Car
+	response = suite.HttpGet("/flaky-cached-cars")
+	suite.DecodeJson(response, &all)
+	assert.Equal(suite.T(), "STALE", response.Header.Get("X-Cache"))
+	assert.Equal(suite.T(), []Car{original}, all)
+
+	response = suite.HttpGet("/flaky-cars/1")
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) restoreFlakyBackend() {
+	suite.FlakyProxy.Enable()
+
+	// give the breaker time to let a probe through and close again
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		response := suite.HttpGet("/flaky-cars/1")
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusServiceUnavailable && response.StatusCode != http.StatusInternalServerError {
+			return
+		}
+		time.Sleep(cacheDuration)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func (suite *IntegrationTestSuite) TestCoalescedConcurrentGetAllFound() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := Car{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("cars.1", car1)
+	suite.PutToRedisAsJson("cars.2", car2)
+
+	// when
+	results := make([][]Car, 50)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			suite.HttpGetJson("/cars", &results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// then
+	for _, result := range results {
+		assert.Equal(suite.T(), 2, len(result))
+		assert.Contains(suite.T(), result, car1)
+		assert.Contains(suite.T(), result, car2)
+	}
+}
+
+func (suite *IntegrationTestSuite) TestMicroCacheServesQueryResultWithinWindow() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("micro-cars.1", car1)
+
+	var first []Car
+	suite.HttpGetJson("/micro-cars?Model=Toyota&Year=2022&Year=2023", &first)
+	assert.Equal(suite.T(), []Car{car1}, first)
+
+	// when
+	car2 := Car{ID: "2", Model: "Toyota", Year: 2023}
+	suite.PutToRedisAsJson("micro-cars.2", car2)
+
+	var cached []Car
+	suite.HttpGetJson("/micro-cars?Year=2023&Year=2022&Model=Toyota", &cached)
+	suite.WaitForCacheDuration()
+	suite.WaitForCacheDuration()
+	var refreshed []Car
+	suite.HttpGetJson("/micro-cars?Model=Toyota&Year=2022&Year=2023", &refreshed)
+
+	// then
+	assert.Equal(suite.T(), []Car{car1}, cached)
+	assert.Equal(suite.T(), 2, len(refreshed))
+	assert.Contains(suite.T(), refreshed, car2)
+}
+
+func (suite *IntegrationTestSuite) TestCoalescedLoadStopsWhenTheLastCallerGivesUp() {
+	// given
+	for i := 0; i < 50; i++ {
+		suite.PutToRedisAsJson(fmt.Sprintf("slow-cars.%d", i), Car{ID: strconv.Itoa(i), Model: "Toyota", Year: 2022})
+	}
+
+	// when
+	response := suite.HttpGet("/slow-cars")
+	_ = response.Body.Close()
+	time.Sleep(100 * time.Millisecond)
+	getsAfterTimeout := suite.SlowProxy.Gets()
+	time.Sleep(500 * time.Millisecond)
+
+	// then
+	assert.Equal(suite.T(), http.StatusGatewayTimeout, response.StatusCode)
+	assert.Less(suite.T(), getsAfterTimeout, int64(50))
+	assert.Equal(suite.T(), getsAfterTimeout, suite.SlowProxy.Gets())
+}
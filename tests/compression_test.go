@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+)
+
+func (suite *IntegrationTestSuite) putCompressedCars() []CachedCar {
+	cars := make([]CachedCar, 0, 10)
+	for i := 1; i <= 10; i++ {
+		car := CachedCar{ID: fmt.Sprint(i), Model: "Toyota", Year: 2010 + i}
+		suite.PutToRedisAsJson(fmt.Sprintf("compressed-cars.%02d", i), car)
+		cars = append(cars, car)
+	}
+	suite.WaitForCacheDuration()
+
+	return cars
+}
+
+func (suite *IntegrationTestSuite) decompress(encoding string, body []byte) []byte {
+	var reader io.Reader
+	switch encoding {
+	case "zstd":
+		decoder, err := zstd.NewReader(bytes.NewReader(body))
+		assert.NoError(suite.T(), err)
+		defer decoder.Close()
+		reader = decoder
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		decoder, err := gzip.NewReader(bytes.NewReader(body))
+		assert.NoError(suite.T(), err)
+		reader = decoder
+	default:
+		return body
+	}
+
+	plain, err := io.ReadAll(reader)
+	assert.NoError(suite.T(), err)
+	return plain
+}
+
+func (suite *IntegrationTestSuite) TestCompressionNegotiated() {
+	// given
+	cars := suite.putCompressedCars()
+
+	for _, encoding := range []string{"zstd", "br", "gzip"} {
+		// when
+		response := suite.HttpGetWithHeaders("/compressed-cars", map[string]string{"Accept-Encoding": encoding})
+		body, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		// then
+		var result []CachedCar
+		assert.Equal(suite.T(), encoding, response.Header.Get("Content-Encoding"))
+		assert.Contains(suite.T(), response.Header.Values("Vary"), "Accept-Encoding")
+		assert.NoError(suite.T(), json.Unmarshal(suite.decompress(encoding, body), &result))
+		assert.Equal(suite.T(), cars, result)
+	}
+}
+
+func (suite *IntegrationTestSuite) TestCompressionPreference() {
+	// given
+	suite.putCompressedCars()
+
+	// when
+	response := suite.HttpGetWithHeaders("/compressed-cars", map[string]string{"Accept-Encoding": "gzip, br;q=0.8, zstd;q=0"})
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), "gzip", response.Header.Get("Content-Encoding"))
+}
+
+func (suite *IntegrationTestSuite) TestCompressionEtagPerEncoding() {
+	// given
+	suite.putCompressedCars()
+
+	// when
+	gzipped := suite.HttpGetWithHeaders("/compressed-cars", map[string]string{"Accept-Encoding": "gzip"})
+	_ = gzipped.Body.Close()
+	plain := suite.HttpGetWithHeaders("/compressed-cars", map[string]string{"Accept-Encoding": "identity"})
+	_ = plain.Body.Close()
+
+	// then
+	assert.Empty(suite.T(), plain.Header.Get("Content-Encoding"))
+	assert.NotEqual(suite.T(), gzipped.Header.Get("ETag"), plain.Header.Get("ETag"))
+}
+
+func (suite *IntegrationTestSuite) TestCompressionFilteredCollection() {
+	// given
+	cars := suite.putCompressedCars()
+
+	// when
+	response := suite.HttpGetWithHeaders("/compressed-cars?Model=Toyota", map[string]string{"Accept-Encoding": "br"})
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	var result []CachedCar
+	assert.Equal(suite.T(), "br", response.Header.Get("Content-Encoding"))
+	assert.NoError(suite.T(), json.Unmarshal(suite.decompress("br", body), &result))
+	assert.Equal(suite.T(), cars, result)
+}
+
+func (suite *IntegrationTestSuite) TestCompressionBelowThreshold() {
+	// given
+	suite.PutToRedisAsJson("cars.1", Car{ID: "1", Model: "Toyota", Year: 2022})
+
+	// when
+	response := suite.HttpGetWithHeaders("/cars/1", map[string]string{"Accept-Encoding": "gzip"})
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), 200, response.StatusCode)
+	assert.Empty(suite.T(), response.Header.Get("Content-Encoding"))
+}
+
+func (suite *IntegrationTestSuite) TestCompressionBelowThresholdKeepsIdentityEtag() {
+	// given
+	suite.putCompressedCars()
+
+	// when
+	gzipped := suite.HttpGetWithHeaders("/compressed-cars?ID=1", map[string]string{"Accept-Encoding": "gzip"})
+	_ = gzipped.Body.Close()
+	plain := suite.HttpGetWithHeaders("/compressed-cars?ID=1", map[string]string{"Accept-Encoding": "identity"})
+	_ = plain.Body.Close()
+
+	// then
+	assert.Empty(suite.T(), gzipped.Header.Get("Content-Encoding"))
+	assert.NotEmpty(suite.T(), gzipped.Header.Get("ETag"))
+	assert.Equal(suite.T(), plain.Header.Get("ETag"), gzipped.Header.Get("ETag"))
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+func (suite *IntegrationTestSuite) TestConditionalGetAllNotModified() {
+	// given
+	suite.PutToRedisAsJson("conditional-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.WaitForCacheDuration()
+	first := suite.HttpGet("/conditional-cars")
+	_ = first.Body.Close()
+	etag := first.Header.Get("ETag")
+
+	// when
+	response := suite.HttpGetWithHeaders("/conditional-cars", map[string]string{"If-None-Match": etag})
+	_ = response.Body.Close()
+
+	// then
+	assert.NotEmpty(suite.T(), etag)
+	assert.NotEmpty(suite.T(), first.Header.Get("Last-Modified"))
+	assert.Equal(suite.T(), "max-age=60, stale-while-revalidate=30", first.Header.Get("Cache-Control"))
+	assert.Equal(suite.T(), http.StatusNotModified, response.StatusCode)
+	assert.Equal(suite.T(), etag, response.Header.Get("ETag"))
+	assert.Equal(suite.T(), "max-age=60, stale-while-revalidate=30", response.Header.Get("Cache-Control"))
+}
+
+func (suite *IntegrationTestSuite) TestConditionalGetAllEtagSurvivesRefreshes() {
+	// given
+	suite.PutToRedisAsJson("conditional-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.WaitForCacheDuration()
+	first := suite.HttpGet("/conditional-cars")
+	_ = first.Body.Close()
+
+	// when
+	suite.WaitForCacheDuration()
+	second := suite.HttpGet("/conditional-cars")
+	_ = second.Body.Close()
+
+	// then
+	assert.NotEqual(suite.T(), first.Header.Get("X-Cache-Generation"), second.Header.Get("X-Cache-Generation"))
+	assert.Equal(suite.T(), first.Header.Get("ETag"), second.Header.Get("ETag"))
+	assert.Equal(suite.T(), first.Header.Get("Last-Modified"), second.Header.Get("Last-Modified"))
+}
+
+func (suite *IntegrationTestSuite) TestConditionalGetAllEtagDependsOnQuery() {
+	// given
+	suite.PutToRedisAsJson("conditional-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.WaitForCacheDuration()
+
+	// when
+	all := suite.HttpGet("/conditional-cars")
+	_ = all.Body.Close()
+	filtered := suite.HttpGet("/conditional-cars?Model=Honda")
+	_ = filtered.Body.Close()
+
+	// then
+	assert.NotEqual(suite.T(), all.Header.Get("ETag"), filtered.Header.Get("ETag"))
+}
+
+func (suite *IntegrationTestSuite) TestConditionalGetOneChangedDocument() {
+	// given
+	suite.PutToRedisAsJson("cars.1", Car{ID: "1", Model: "Toyota", Year: 2022})
+	first := suite.HttpGet("/cars/1")
+	_ = first.Body.Close()
+	etag := first.Header.Get("ETag")
+
+	// when
+	unchanged := suite.HttpGetWithHeaders("/cars/1", map[string]string{"If-None-Match": etag})
+	_ = unchanged.Body.Close()
+	suite.PutToRedisAsJson("cars.1", Car{ID: "1", Model: "Toyota", Year: 2023})
+	changed := suite.HttpGetWithHeaders("/cars/1", map[string]string{"If-None-Match": etag})
+	_ = changed.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusNotModified, unchanged.StatusCode)
+	assert.Equal(suite.T(), http.StatusOK, changed.StatusCode)
+	assert.NotEqual(suite.T(), etag, changed.Header.Get("ETag"))
+	assert.Empty(suite.T(), changed.Header.Get("Cache-Control"))
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	. "redis-go-dispatcher/config"
+	"testing"
+	"time"
+)
+
+func TestRedisConfigValid(t *testing.T) {
+	redisConfig := RedisConfig{
+		URL:            "redis://localhost:6379/2",
+		PoolMaxIdle:    3,
+		PoolMaxActive:  10,
+		ConnectTimeout: time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   time.Second,
+		Wait:           true,
+	}
+
+	assert.NoError(t, redisConfig.Validate())
+}
+
+func TestRedisConfigInvalid(t *testing.T) {
+	negative := -1
+	redisConfig := RedisConfig{
+		URL:          "http://localhost:6379",
+		Password:     "secret",
+		PasswordFile: "/does/not/exist",
+		DB:           &negative,
+		ReadTimeout:  -time.Second,
+		TLS:          TLSConfig{Enabled: true, CertFile: "client.pem"},
+	}
+
+	err := redisConfig.Validate()
+
+	assert.ErrorContains(t, err, "unsupported scheme")
+	assert.ErrorContains(t, err, "mutually exclusive")
+	assert.ErrorContains(t, err, "redis.password_file")
+	assert.ErrorContains(t, err, "redis.db")
+	assert.ErrorContains(t, err, "redis.read_timeout")
+	assert.ErrorContains(t, err, "must be set together")
+}
+
+func TestRedisConfigExplicitDefaultDB(t *testing.T) {
+	var explicit, omitted RedisConfig
+	assert.NoError(t, yaml.Unmarshal([]byte("url: redis://localhost:6379/3\ndb: 0"), &explicit))
+	assert.NoError(t, yaml.Unmarshal([]byte("url: redis://localhost:6379/3"), &omitted))
+
+	assert.Equal(t, 0, *explicit.DB)
+	assert.Nil(t, omitted.DB)
+}
+
+func TestRedisConfigTLSOptionsWithoutTLS(t *testing.T) {
+	redisConfig := RedisConfig{
+		URL: "redis://localhost:6379",
+		TLS: TLSConfig{ServerName: "redis.internal"},
+	}
+
+	assert.ErrorContains(t, redisConfig.Validate(), "TLS is not enabled")
+
+	redisConfig.URL = "rediss://localhost:6379"
+	assert.NoError(t, redisConfig.Validate())
+}
+
+func TestConfigUnknownBackend(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		RedisBackends: map[string]RedisConfig{
+			"reports": {URL: "redis://reports:6379"},
+		},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars."},
+			{URI: "/reports", RedisPrefix: "reports.", Redis: "reports"},
+			{URI: "/orders", RedisPrefix: "orders.", Redis: "orders"},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, `prefix /orders: unknown redis backend "orders"`)
+	assert.NotContains(t, err.Error(), "/reports")
+}
+
+func TestConfigReportsAllProblemsOfAPrefix(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars.", Redis: "orders", CacheMode: "eager", InvalidJson: "ignore", RequestTimeout: -time.Second},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, `prefix /cars: unknown redis backend "orders"`)
+	assert.ErrorContains(t, err, `prefix /cars: unknown cache_mode "eager"`)
+	assert.ErrorContains(t, err, `prefix /cars: unknown invalid_json "ignore"`)
+	assert.ErrorContains(t, err, "prefix /cars: request_timeout must not be negative")
+}
+
+func TestConfigIndexesRequireCache(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars.", CacheEnabled: true, Indexes: []string{"color", "owner.id", "tags[len]"}},
+			{URI: "/trucks", RedisPrefix: "trucks.", CacheEnabled: true, Indexes: []string{"wheels[all].size"}},
+			{URI: "/people", RedisPrefix: "people.", Indexes: []string{"name"}},
+			{URI: "/orders", RedisPrefix: "orders.", CacheEnabled: true, Indexes: []string{"owner..id"}},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "prefix /people: indexes require cache_enabled")
+	assert.ErrorContains(t, err, `prefix /orders: invalid index field "owner..id"`)
+	assert.ErrorContains(t, err, `prefix /trucks: index field "wheels[all].size" must not use [all] or [none]`)
+	assert.NotContains(t, err.Error(), "/cars")
+}
+
+func TestConfigSearchFields(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars.", SearchFields: []string{"name^2.5", "owner.name"}},
+			{URI: "/people", RedisPrefix: "people.", SearchFields: []string{"name^0"}},
+			{URI: "/orders", RedisPrefix: "orders.", SearchFields: []string{".title"}},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, `prefix /people: search field "name^0" needs a positive weight`)
+	assert.ErrorContains(t, err, `prefix /orders: invalid search field ".title"`)
+	assert.NotContains(t, err.Error(), "/cars")
+}
+
+func TestConfigInvalidJsonPolicy(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars.", InvalidJson: InvalidJsonFail},
+			{URI: "/people", RedisPrefix: "people.", InvalidJson: "ignore"},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, `prefix /people: unknown invalid_json "ignore"`)
+	assert.NotContains(t, err.Error(), "/cars")
+}
+
+func TestConfigSchemaPolicy(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/cars", RedisPrefix: "cars.", Schema: "testdata/car.schema.json", SchemaPolicy: SchemaPolicyDrop},
+			{URI: "/people", RedisPrefix: "people.", SchemaPolicy: SchemaPolicyAnnotate},
+			{URI: "/trucks", RedisPrefix: "trucks.", Schema: "testdata/car.schema.json", SchemaPolicy: "reject"},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "prefix /people: schema_policy annotate requires a schema")
+	assert.ErrorContains(t, err, `prefix /trucks: unknown schema_policy "reject"`)
+	assert.NotContains(t, err.Error(), "/cars")
+}
+
+func TestConfigRelations(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/people", RedisPrefix: "people."},
+			{URI: "/cars", RedisPrefix: "cars.", Relations: map[string]Relation{
+				"owner":   {Field: "ownerId", Prefix: "/people"},
+				"dealer":  {Field: "dealerId", Prefix: "/dealers"},
+				"a.b":     {Field: "id", Prefix: "/people"},
+				"nothing": {Prefix: "/people"},
+			}},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, `prefix /cars: relation dealer points at unknown prefix "/dealers"`)
+	assert.ErrorContains(t, err, `prefix /cars: invalid relation name "a.b"`)
+	assert.ErrorContains(t, err, "prefix /cars: relation nothing needs a field")
+	assert.NotContains(t, err.Error(), "relation owner")
+}
+
+func TestConfigRedisKeyTemplates(t *testing.T) {
+	config := Config{
+		Redis: RedisConfig{URL: "redis://localhost:6379"},
+		Prefixes: []Prefix{
+			{URI: "/tenants/:tenant/orders", RedisKey: "tenant:{tenant}:order:{id}"},
+			{URI: "/tenants/:tenant/invoices", RedisKey: "tenant:{tenant}:invoice"},
+			{URI: "/tenants/:tenant/users", RedisKey: "user:{id}"},
+			{URI: "/regions/:region/shops", RedisKey: "region:{region}:shop:{id}", CacheEnabled: true},
+			{URI: "/carts", RedisKey: "cart:{owner}:{id}"},
+			{URI: "/people", RedisKey: "person:{id}:json", CacheEnabled: true},
+		},
+	}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "prefix /tenants/:tenant/invoices: redis_key must contain {id} exactly once")
+	assert.ErrorContains(t, err, "prefix /tenants/:tenant/users: path parameter :tenant is not used in redis_key")
+	assert.ErrorContains(t, err, "prefix /regions/:region/shops: cache_enabled is not supported with path parameters")
+	assert.ErrorContains(t, err, "prefix /carts: redis_key placeholder {owner} has no path parameter")
+	assert.NotContains(t, err.Error(), "/orders")
+	assert.NotContains(t, err.Error(), "/people")
+}
+
+func TestConfigCacheBudgets(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+redis:
+  url: "redis://localhost:6379"
+cache:
+  memory_limit: 1GB
+prefixes:
+  - uri: /cars
+    cache_enabled: true
+    cache_max_bytes: 256MB
+  - uri: /people
+    cache_enabled: true
+  - uri: /orders
+    cache_enabled: true
+  - uri: /uncached
+`), &config)
+
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, ByteSize(256<<20), config.CacheBudget(config.Prefixes[0]))
+	assert.Equal(t, ByteSize(384<<20), config.CacheBudget(config.Prefixes[1]))
+	assert.Equal(t, ByteSize(384<<20), config.CacheBudget(config.Prefixes[2]))
+
+	config.Prefixes[1].CacheMaxBytes = 1 << 30
+	assert.ErrorContains(t, config.Validate(), "exceed cache.memory_limit")
+}
+
+func TestConfigGraphQLLimits(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+redis:
+  url: "redis://localhost:6379"
+graphql:
+  max_depth: -1
+  max_complexity: 500
+  infer_refresh: -1s
+prefixes:
+  - uri: /cars
+`), &config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 500, config.GraphQL.MaxComplexity)
+	assert.ErrorContains(t, config.Validate(), "graphql.max_depth must not be negative, got -1")
+	assert.ErrorContains(t, config.Validate(), "graphql.infer_refresh must not be negative, got -1s")
+}
+
+func TestByteSizeParsing(t *testing.T) {
+	for text, expected := range map[string]ByteSize{
+		"512":    512,
+		"64KB":   64 << 10,
+		"64 MiB": 64 << 20,
+		"2gb":    2 << 30,
+	} {
+		size, err := ParseByteSize(text)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, size, text)
+	}
+
+	_, err := ParseByteSize("lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+type OwnedCar struct {
+	ID      string
+	Model   string
+	OwnerID string
+	Owner   *Owner `json:",omitempty"`
+}
+
+type Owner struct {
+	ID     string
+	Name   string
+	CarIDs []string
+	Cars   []OwnedCar `json:",omitempty"`
+}
+
+func (suite *IntegrationTestSuite) TestExpandGetOne() {
+	// given
+	owner := Owner{ID: "7", Name: "Alice", CarIDs: []string{"1"}}
+	suite.PutToRedisAsJson("owners.7", owner)
+	suite.PutToRedisAsJson("owned-cars.1", OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7"})
+
+	// when
+	var result OwnedCar
+	suite.HttpGetJson("/owned-cars/1?_expand=owner", &result)
+
+	// then
+	assert.Equal(suite.T(), OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7", Owner: &owner}, result)
+}
+
+func (suite *IntegrationTestSuite) TestExpandGetAllWithFilter() {
+	// given
+	owner := Owner{ID: "7", Name: "Alice"}
+	suite.PutToRedisAsJson("owners.7", owner)
+	suite.PutToRedisAsJson("owned-cars.1", OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7"})
+	suite.PutToRedisAsJson("owned-cars.2", OwnedCar{ID: "2", Model: "Honda", OwnerID: "7"})
+	suite.PutToRedisAsJson("owned-cars.3", OwnedCar{ID: "3", Model: "Toyota", OwnerID: "8"})
+
+	// when
+	var result []OwnedCar
+	suite.HttpGetJson("/owned-cars?_expand=owner&Model=Toyota", &result)
+
+	// then
+	assert.ElementsMatch(suite.T(), []OwnedCar{
+		{ID: "1", Model: "Toyota", OwnerID: "7", Owner: &owner},
+		{ID: "3", Model: "Toyota", OwnerID: "8"},
+	}, result)
+}
+
+func (suite *IntegrationTestSuite) TestExpandNestedStopsAtCycle() {
+	// given
+	suite.PutToRedisAsJson("owners.7", Owner{ID: "7", Name: "Alice", CarIDs: []string{"1", "2"}})
+	suite.PutToRedisAsJson("owned-cars.1", OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7"})
+	suite.PutToRedisAsJson("owned-cars.2", OwnedCar{ID: "2", Model: "Honda", OwnerID: "7"})
+
+	// when
+	var result Owner
+	suite.HttpGetJson("/owners/7?_expand=cars.owner", &result)
+
+	// then
+	assert.Equal(suite.T(), []OwnedCar{
+		{ID: "1", Model: "Toyota", OwnerID: "7"},
+		{ID: "2", Model: "Honda", OwnerID: "7"},
+	}, result.Cars)
+}
+
+func (suite *IntegrationTestSuite) TestExpandCollectionStopsAtCycle() {
+	// given
+	suite.PutToRedisAsJson("owners.7", Owner{ID: "7", Name: "Alice", CarIDs: []string{"1", "2"}})
+	suite.PutToRedisAsJson("owned-cars.1", OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7"})
+	suite.PutToRedisAsJson("owned-cars.2", OwnedCar{ID: "2", Model: "Honda", OwnerID: "7"})
+
+	// when
+	var result []Owner
+	suite.HttpGetJson("/owners?_expand=cars.owner", &result)
+
+	// then
+	assert.Len(suite.T(), result, 1)
+	assert.Equal(suite.T(), []OwnedCar{
+		{ID: "1", Model: "Toyota", OwnerID: "7"},
+		{ID: "2", Model: "Honda", OwnerID: "7"},
+	}, result[0].Cars)
+}
+
+func (suite *IntegrationTestSuite) TestExpandBatch() {
+	// given
+	owner := Owner{ID: "7", Name: "Alice"}
+	suite.PutToRedisAsJson("owners.7", owner)
+	suite.PutToRedisAsJson("owned-cars.1", OwnedCar{ID: "1", Model: "Toyota", OwnerID: "7"})
+
+	// when
+	var result []*OwnedCar
+	suite.HttpGetJson("/owned-cars?_ids=1,2&_expand=owner", &result)
+
+	// then
+	assert.Equal(suite.T(), []*OwnedCar{{ID: "1", Model: "Toyota", OwnerID: "7", Owner: &owner}, nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestExpandUnknownRelation() {
+	// when
+	response := suite.HttpGet("/owned-cars?_expand=dealer")
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestExpandTooDeep() {
+	// when
+	response := suite.HttpGet("/owners/7?_expand=cars.owner.cars.owner")
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+type FacetValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+func (suite *IntegrationTestSuite) putFacetedCars(prefix string) {
+	suite.PutToRedisAsJson(prefix+"1", map[string]interface{}{"color": "red", "brand": "BMW", "year": 2021, "tags": []string{"sale", "new"}, "owner": map[string]interface{}{"city": "Berlin"}})
+	suite.PutToRedisAsJson(prefix+"2", map[string]interface{}{"color": "red", "brand": "Audi", "year": 2022, "tags": []string{"sale"}})
+	suite.PutToRedisAsJson(prefix+"3", map[string]interface{}{"color": "blue", "brand": "BMW", "year": 2023, "owner": map[string]interface{}{"city": "Berlin"}})
+	suite.PutToRedisAsJson(prefix+"4", map[string]interface{}{"color": "green", "brand": "BMW", "year": 2015})
+}
+
+func (suite *IntegrationTestSuite) TestFacetsAfterFilters() {
+	// given
+	suite.putFacetedCars("cars.")
+
+	// when
+	var result map[string][]FacetValue
+	suite.HttpGetJson("/cars/_facets?_fields=color,brand&year[gte]=2020", &result)
+
+	// then
+	assert.Equal(suite.T(), map[string][]FacetValue{
+		"color": {{"red", 2}, {"blue", 1}},
+		"brand": {{"BMW", 2}, {"Audi", 1}},
+	}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFacetsNestedAndArrayFieldsOnSnapshot() {
+	// given
+	suite.putFacetedCars("cached-cars.")
+	suite.WaitForCacheDuration()
+
+	// when
+	var result map[string][]FacetValue
+	suite.HttpGetJson("/cached-cars/_facets?_fields=owner.city,tags,year&_limit=1", &result)
+
+	// then
+	assert.Equal(suite.T(), map[string][]FacetValue{
+		"owner.city": {{"Berlin", 2}},
+		"tags":       {{"sale", 2}},
+		"year":       {{2015.0, 1}},
+	}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFacetsRequireFields() {
+	// when
+	response := suite.HttpGet("/cars/_facets?color=red")
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestFacetsRejectOtherReservedParameters() {
+	// given
+	suite.putFacetedCars("cars.")
+
+	// when
+	expand := suite.HttpGet("/cars/_facets?_fields=color&_expand=owner")
+	_ = expand.Body.Close()
+	groupBy := suite.HttpGet("/cars/_facets?_fields=color&_groupBy=color")
+	_ = groupBy.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, expand.StatusCode)
+	assert.Equal(suite.T(), http.StatusBadRequest, groupBy.StatusCode)
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (suite *IntegrationTestSuite) putFilterQueries() (Query, Query, Query) {
+	query1 := Query{ID: "1", Name: "TestQuery", Number: 1, FloatNumber: 1.1, Ok: true}
+	query2 := Query{ID: "2", Name: "OtherQuery", Number: 2, FloatNumber: 2.2, Ok: false}
+	query3 := Query{ID: "3", Name: "LastQuery", Number: 3, FloatNumber: 3.3, Ok: true}
+	suite.PutToRedisAsJson("query.1", query1)
+	suite.PutToRedisAsJson("query.2", query2)
+	suite.PutToRedisAsJson("query.3", query3)
+
+	return query1, query2, query3
+}
+
+func (suite *IntegrationTestSuite) TestFilterOrWithGrouping() {
+	// given
+	query1, query2, _ := suite.putFilterQueries()
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`Name = "TestQuery" OR (Number > 1 AND Ok = false)`), &result)
+
+	// then
+	assert.ElementsMatch(suite.T(), []Query{query1, query2}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFilterNotAndStringFunctions() {
+	// given
+	_, _, query3 := suite.putFilterQueries()
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`endsWith(Name, 'Query') && !(startsWith(Name, "Test") || contains(Name, "ther"))`), &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{query3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFilterAndedWithSimpleParameters() {
+	// given
+	_, _, query3 := suite.putFilterQueries()
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?Ok=true&_filter="+url.QueryEscape(`FloatNumber >= 2`), &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{query3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFilterNullAndMissingFields() {
+	// given
+	suite.PutToRedisAsJson("query.1", map[string]interface{}{"ID": "1", "Name": nil})
+	suite.PutToRedisAsJson("query.2", map[string]interface{}{"ID": "2"})
+
+	// when
+	var isNull []map[string]interface{}
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`Name = null`), &isNull)
+	var notNull []map[string]interface{}
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(`Name != null`), &notNull)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{{"ID": "1", "Name": nil}}, isNull)
+	assert.Equal(suite.T(), []map[string]interface{}{{"ID": "2"}}, notNull)
+}
+
+func (suite *IntegrationTestSuite) TestFilterOnCachedPrefix() {
+	// given
+	query1 := Query{ID: "1", Name: "TestQuery", Number: 1, FloatNumber: 1.1, Ok: true}
+	query2 := Query{ID: "2", Name: "TestQuery2", Number: 2, FloatNumber: 2.2, Ok: false}
+	suite.PutToRedisAsJson("cached-query.1", query1)
+	suite.PutToRedisAsJson("cached-query.2", query2)
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/cached-query?_filter="+url.QueryEscape(`NOT Ok = true`), &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{query2}, result)
+}
+
+func (suite *IntegrationTestSuite) TestFilterSyntaxErrorPosition() {
+	// when
+	response := suite.HttpGet("/query?_filter=" + url.QueryEscape(`Name = "x" AND (Number > 1`))
+
+	// then
+	var body struct {
+		Message  string
+		Position int
+	}
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+	suite.DecodeJson(response, &body)
+	assert.Equal(suite.T(), 27, body.Position)
+	assert.Contains(suite.T(), body.Message, "expected ')'")
+}
+
+func (suite *IntegrationTestSuite) TestFilterUnknownFunction() {
+	// when
+	response := suite.HttpGet("/query?_filter=" + url.QueryEscape(`matches(Name, "x")`))
+
+	// then
+	var body struct {
+		Message  string
+		Position int
+	}
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+	suite.DecodeJson(response, &body)
+	assert.Equal(suite.T(), 1, body.Position)
+}
+
+func (suite *IntegrationTestSuite) TestFilterNestingTooDeep() {
+	// when
+	response := suite.HttpGet("/query?_filter=" + url.QueryEscape(strings.Repeat("(", 65)+`Name = "x"`+strings.Repeat(")", 65)))
+
+	// then
+	var body struct {
+		Message  string
+		Position int
+	}
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+	suite.DecodeJson(response, &body)
+	assert.Equal(suite.T(), 65, body.Position)
+	assert.Contains(suite.T(), body.Message, "nested deeper than 64")
+}
+
+func (suite *IntegrationTestSuite) TestFilterNestingAtLimit() {
+	// given
+	query1 := Query{ID: "1", Name: "TestQuery"}
+	suite.PutToRedisAsJson("query.1", query1)
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?_filter="+url.QueryEscape(strings.Repeat("NOT ", 32)+strings.Repeat("(", 32)+`Name = "TestQuery"`+strings.Repeat(")", 32)), &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{query1}, result)
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"github.com/stretchr/testify/assert"
+	"net/http"
 )
 
 type Car struct {
@@ -177,3 +178,30 @@ func (suite *IntegrationTestSuite) TestGetAllMultiplePrefixesNoElementsReturnsEm
 	assert.Equal(suite.T(), 0, len(resultCars))
 	assert.Equal(suite.T(), 0, len(resultPeople))
 }
+
+func (suite *IntegrationTestSuite) TestGetByReservedIdIsRejected() {
+	// given
+	suite.PutToRedisAsJson("cars._batch", Car{ID: "_batch", Model: "Toyota", Year: 2022})
+
+	for _, id := range []string{"_batch", "_validate"} {
+		// when
+		response := suite.HttpGet("/cars/" + id)
+		_ = response.Body.Close()
+
+		// then
+		assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode, id)
+	}
+}
+
+func (suite *IntegrationTestSuite) TestGetReservedIdByIds() {
+	// given
+	original := Car{ID: "_facets", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("cars._facets", original)
+
+	// when
+	var result []Car
+	suite.HttpGetJson("/cars?_ids=_facets", &result)
+
+	// then
+	assert.Equal(suite.T(), []Car{original}, result)
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type GraphQLResponse struct {
+	Data   map[string]interface{}
+	Errors []struct {
+		Message string
+	}
+}
+
+func (suite *IntegrationTestSuite) PostGraphQL(query string, variables map[string]interface{}) (int, GraphQLResponse) {
+	resp := suite.HttpPostJson("/graphql", map[string]interface{}{"query": query, "variables": variables})
+	var result GraphQLResponse
+	suite.DecodeJson(resp, &result)
+
+	return resp.StatusCode, result
+}
+
+func (suite *IntegrationTestSuite) putGraphData() {
+	suite.PutToRedisAsJson("graph-people.7", map[string]interface{}{"ID": "7", "Name": "Alice", "Age": 34, "Address": map[string]interface{}{"City": "Oslo"}, "CarIDs": []string{"1", "2"}})
+	suite.PutToRedisAsJson("graph-people.8", map[string]interface{}{"ID": "8", "Name": "Bob", "CarIDs": []string{"3"}})
+	suite.PutToRedisAsJson("graph-cars.1", map[string]interface{}{"ID": "1", "Model": "Golf", "Year": 2012, "OwnerID": "7", "Tags": []string{"red"}})
+	suite.PutToRedisAsJson("graph-cars.2", map[string]interface{}{"ID": "2", "Model": "Polo", "Year": 2019, "OwnerID": "7"})
+	suite.PutToRedisAsJson("graph-cars.3", map[string]interface{}{"ID": "3", "Model": "Golf", "Year": 2021, "OwnerID": "8"})
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLListWithFiltersAndRelation() {
+	// given
+	suite.putGraphData()
+
+	// when
+	status, result := suite.PostGraphQL(`{ graphCars(Model: "Golf", Year_gt: 2010) { ID Year Tags owner { Name Address { City } } } }`, nil)
+
+	// then
+	assert.Equal(suite.T(), http.StatusOK, status)
+	assert.Empty(suite.T(), result.Errors)
+	assert.ElementsMatch(suite.T(), []interface{}{
+		map[string]interface{}{"ID": "1", "Year": float64(2012), "Tags": []interface{}{"red"}, "owner": map[string]interface{}{"Name": "Alice", "Address": map[string]interface{}{"City": "Oslo"}}},
+		map[string]interface{}{"ID": "3", "Year": float64(2021), "Tags": nil, "owner": map[string]interface{}{"Name": "Bob", "Address": nil}},
+	}, result.Data["graphCars"])
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLFilterExpressionAndSearch() {
+	// given
+	suite.putGraphData()
+
+	// when
+	_, filtered := suite.PostGraphQL(`{ graphCars(_filter: "Year >= 2019", Model: ["Polo", "Golf"]) { ID } }`, nil)
+	_, searched := suite.PostGraphQL(`{ graphCars(_q: "polo") { ID } }`, nil)
+
+	// then
+	assert.ElementsMatch(suite.T(), []interface{}{
+		map[string]interface{}{"ID": "2"},
+		map[string]interface{}{"ID": "3"},
+	}, filtered.Data["graphCars"])
+	assert.Equal(suite.T(), []interface{}{map[string]interface{}{"ID": "2"}}, searched.Data["graphCars"])
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLByIdWithFragmentsAndVariables() {
+	// given
+	suite.putGraphData()
+
+	// when
+	status, result := suite.PostGraphQL(`
+		query Person($id: ID!, $withCars: Boolean = true) {
+			person: graphPeopleById(id: $id) { ...names cars @include(if: $withCars) { Model } }
+			missing: graphPeopleById(id: "9") { Name }
+		}
+		fragment names on GraphPeople { Name Age __typename }`,
+		map[string]interface{}{"id": "7"})
+
+	// then
+	assert.Equal(suite.T(), http.StatusOK, status)
+	assert.Empty(suite.T(), result.Errors)
+	assert.Equal(suite.T(), map[string]interface{}{
+		"person": map[string]interface{}{
+			"Name":       "Alice",
+			"Age":        float64(34),
+			"__typename": "GraphPeople",
+			"cars":       []interface{}{map[string]interface{}{"Model": "Golf"}, map[string]interface{}{"Model": "Polo"}},
+		},
+		"missing": nil,
+	}, result.Data)
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLIntrospection() {
+	// when
+	_, result := suite.PostGraphQL(`{
+		__schema { queryType { name } }
+		__type(name: "GraphCars") { fields { name type { kind name ofType { name } } } }
+	}`, nil)
+
+	// then
+	assert.Empty(suite.T(), result.Errors)
+	assert.Equal(suite.T(), map[string]interface{}{"name": "Query"}, result.Data["__schema"].(map[string]interface{})["queryType"])
+	fields := result.Data["__type"].(map[string]interface{})["fields"].([]interface{})
+	assert.Contains(suite.T(), fields, map[string]interface{}{"name": "Year", "type": map[string]interface{}{"kind": "SCALAR", "name": "Int", "ofType": nil}})
+	assert.Contains(suite.T(), fields, map[string]interface{}{"name": "owner", "type": map[string]interface{}{"kind": "OBJECT", "name": "GraphPeople", "ofType": nil}})
+	assert.Contains(suite.T(), fields, map[string]interface{}{"name": "Tags", "type": map[string]interface{}{"kind": "LIST", "name": nil, "ofType": map[string]interface{}{"name": "String"}}})
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLDocumentWithoutSchema() {
+	// given
+	suite.PutToRedisAsJson("people.1", map[string]interface{}{"name": "Carol"})
+
+	// when
+	_, result := suite.PostGraphQL(`{ peopleById(id: "1") { _document } }`, nil)
+
+	// then
+	assert.Empty(suite.T(), result.Errors)
+	assert.Equal(suite.T(), map[string]interface{}{"_document": map[string]interface{}{"name": "Carol"}}, result.Data["peopleById"])
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLDepthLimit() {
+	// when
+	status, result := suite.PostGraphQL(`{ graphCars { owner { cars { owner { cars { owner { Name } } } } } } }`, nil)
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, status)
+	assert.Nil(suite.T(), result.Data)
+	assert.Equal(suite.T(), "Query depth 7 exceeds the limit of 6.", result.Errors[0].Message)
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLComplexityLimit() {
+	// when
+	status, result := suite.PostGraphQL(`{ graphPeople { ID Name cars { ID Model owner { Name cars { ID Model Year } } } } }`, nil)
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, status)
+	assert.Equal(suite.T(), "Query complexity 3531 exceeds the limit of 2000.", result.Errors[0].Message)
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLRejectsInvalidQueries() {
+	// when
+	syntaxStatus, syntax := suite.PostGraphQL(`{ graphCars { ID }`, nil)
+	unknownStatus, unknown := suite.PostGraphQL(`{ graphCars { Color } }`, nil)
+	mutationStatus, mutation := suite.PostGraphQL(`mutation { graphCars { ID } }`, nil)
+	malformed := suite.HttpPostJson("/graphql", "not an object")
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, syntaxStatus)
+	assert.Contains(suite.T(), syntax.Errors[0].Message, "Syntax Error")
+	assert.Equal(suite.T(), http.StatusBadRequest, unknownStatus)
+	assert.Equal(suite.T(), `Cannot query field "Color" on type "GraphCars".`, unknown.Errors[0].Message)
+	assert.Equal(suite.T(), http.StatusBadRequest, mutationStatus)
+	assert.Equal(suite.T(), "Schema is not configured for mutations", mutation.Errors[0].Message)
+	assert.Equal(suite.T(), http.StatusBadRequest, malformed.StatusCode)
+	_ = malformed.Body.Close()
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLValidatesSelectionsAndVariables() {
+	// when
+	conflictStatus, conflict := suite.PostGraphQL(`{ graphCarsById(id: "1") { x: Model x: Year } }`, nil)
+	undefinedStatus, undefined := suite.PostGraphQL(`{ graphCarsById(id: $id) { Model } }`, map[string]interface{}{"id": "1"})
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, conflictStatus)
+	assert.Contains(suite.T(), conflict.Errors[0].Message, `Fields "x" conflict`)
+	assert.Equal(suite.T(), http.StatusBadRequest, undefinedStatus)
+	assert.Equal(suite.T(), `Variable "$id" is not defined.`, undefined.Errors[0].Message)
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLInfersFieldsOfPrefixesWithoutSchema() {
+	// given
+	_, before := suite.PostGraphQL(`{ peopleById(id: "1") { nickname } }`, nil)
+	suite.PutToRedisAsJson("people.1", map[string]interface{}{"nickname": "Caz", "age": 41})
+
+	// when
+	var result GraphQLResponse
+	inferred := func() bool {
+		_, result = suite.PostGraphQL(`{ peopleById(id: "1") { nickname age } }`, nil)
+		return len(result.Errors) == 0
+	}
+
+	// then
+	assert.NotEmpty(suite.T(), before.Errors, "no document had the field yet")
+	assert.Eventually(suite.T(), inferred, 2*time.Second, 20*time.Millisecond, "the fields are sampled again")
+	assert.Equal(suite.T(), map[string]interface{}{"nickname": "Caz", "age": float64(41)}, result.Data["peopleById"])
+}
+
+func (suite *IntegrationTestSuite) TestGraphQLOverGet() {
+	// given
+	suite.putGraphData()
+
+	// when
+	var result GraphQLResponse
+	suite.HttpGetJson("/graphql?query="+url.QueryEscape(`query($id: ID!) { graphCarsById(id: $id) { Model } }`)+
+		"&variables="+url.QueryEscape(`{"id": "2"}`), &result)
+
+	// then
+	assert.Empty(suite.T(), result.Errors)
+	assert.Equal(suite.T(), map[string]interface{}{"Model": "Polo"}, result.Data["graphCarsById"])
+}
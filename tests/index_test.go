@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IntegrationTestSuite) putIndexedCars() (CachedCar, CachedCar, CachedCar) {
+	car1 := CachedCar{ID: "1", Model: "Toyota", Year: 2019}
+	car2 := CachedCar{ID: "2", Model: "Honda", Year: 2021}
+	car3 := CachedCar{ID: "3", Model: "Toyota", Year: 2023}
+	suite.PutToRedisAsJson("indexed-cars.1", car1)
+	suite.PutToRedisAsJson("indexed-cars.2", car2)
+	suite.PutToRedisAsJson("indexed-cars.3", car3)
+	suite.WaitForCacheDuration()
+
+	return car1, car2, car3
+}
+
+func (suite *IntegrationTestSuite) TestIndexedQueryEquality() {
+	// given
+	car1, _, car3 := suite.putIndexedCars()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/indexed-cars?Model=Toyota", &result)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car1, car3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestIndexedQueryRange() {
+	// given
+	_, car2, car3 := suite.putIndexedCars()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/indexed-cars?Year[gte]=2020&Year[lt]=2030", &result)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car2, car3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestIndexedQueryCombinedWithScan() {
+	// given
+	_, _, car3 := suite.putIndexedCars()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/indexed-cars?Model=Toyota&Year[gt]=2019&ID=3", &result)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestQueryRangeWithoutIndex() {
+	// given
+	originalQuery1 := Query{ID: "1", Name: "TestQuery", Number: 1, FloatNumber: 1.1, Ok: true}
+	originalQuery2 := Query{ID: "2", Name: "TestQuery2", Number: 2, FloatNumber: 2.2, Ok: false}
+	suite.PutToRedisAsJson("query.1", originalQuery1)
+	suite.PutToRedisAsJson("query.2", originalQuery2)
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?FloatNumber[lte]=1.5", &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{originalQuery1}, result)
+}
+
+func (suite *IntegrationTestSuite) TestQueryRangeNotANumber() {
+	// when
+	response := suite.HttpGet("/indexed-cars?Year[gt]=recent")
+
+	// then
+	assert.Equal(suite.T(), 400, response.StatusCode)
+}
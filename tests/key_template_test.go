@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"redis-go-dispatcher/service"
+	"testing"
+)
+
+type Order struct {
+	ID     string
+	Tenant string
+	Total  int
+}
+
+func (suite *IntegrationTestSuite) putTenantOrders() (Order, Order, Order) {
+	order1 := Order{ID: "1", Tenant: "a", Total: 10}
+	order2 := Order{ID: "2", Tenant: "a", Total: 20}
+	order3 := Order{ID: "1", Tenant: "b", Total: 30}
+	suite.PutToRedisAsJson("tenant:a:order:1", order1)
+	suite.PutToRedisAsJson("tenant:a:order:2", order2)
+	suite.PutToRedisAsJson("tenant:b:order:1", order3)
+
+	return order1, order2, order3
+}
+
+func (suite *IntegrationTestSuite) TestKeyTemplateCollectionIsScoped() {
+	// given
+	order1, order2, _ := suite.putTenantOrders()
+
+	// when
+	var result []Order
+	suite.HttpGetJson("/tenants/a/orders", &result)
+
+	// then
+	assert.ElementsMatch(suite.T(), []Order{order1, order2}, result)
+}
+
+func (suite *IntegrationTestSuite) TestKeyTemplateGetOne() {
+	// given
+	_, _, order3 := suite.putTenantOrders()
+
+	// when
+	var result Order
+	suite.HttpGetJson("/tenants/b/orders/1", &result)
+	missing := suite.HttpGet("/tenants/b/orders/2")
+	_ = missing.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), order3, result)
+	assert.Equal(suite.T(), http.StatusNotFound, missing.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestKeyTemplateQueryAndBatch() {
+	// given
+	_, order2, _ := suite.putTenantOrders()
+
+	// when
+	var filtered []Order
+	suite.HttpGetJson("/tenants/a/orders?Total[gt]=15", &filtered)
+	var batch []*Order
+	suite.HttpGetJson("/tenants/a/orders?_ids=2,3", &batch)
+
+	// then
+	assert.Equal(suite.T(), []Order{order2}, filtered)
+	assert.Equal(suite.T(), []*Order{&order2, nil}, batch)
+}
+
+func (suite *IntegrationTestSuite) TestKeyTemplateRejectsGlobCharacters() {
+	// given
+	suite.putTenantOrders()
+
+	for _, tenant := range []string{"*", "a%3F", "%5Bab%5D"} {
+		// when
+		response := suite.HttpGet("/tenants/" + tenant + "/orders")
+		_ = response.Body.Close()
+
+		// then
+		assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode, tenant)
+	}
+}
+
+func TestKeyTemplateId(t *testing.T) {
+	template, err := service.ParseKeyTemplate("tenant:{tenant}:order:{id}:v1")
+	assert.NoError(t, err)
+	bound, err := template.Bind(map[string]string{"tenant": "a"})
+	assert.NoError(t, err)
+
+	id, found := bound.Id("tenant:a:order:42:v1")
+	assert.True(t, found)
+	assert.Equal(t, "42", id)
+
+	_, found = bound.Id("tenant:b:order:42:v1")
+	assert.False(t, found)
+	_, found = template.Id("tenant:a:order:42:v1")
+	assert.False(t, found)
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"time"
+)
+
+type OpenAPIDocument struct {
+	OpenAPI    string                            `json:"openapi"`
+	Paths      map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+func (suite *IntegrationTestSuite) TestOpenAPIDocument() {
+	// when
+	var document OpenAPIDocument
+	suite.HttpGetJson("/_openapi.json", &document)
+
+	// then
+	assert.Equal(suite.T(), "3.1.0", document.OpenAPI)
+	for _, path := range []string{"/cars", "/cars/{id}", "/cars/_batch", "/cars/_aggregate", "/cars/_facets", "/tenants/{tenant}/orders/{id}"} {
+		assert.Contains(suite.T(), document.Paths, path)
+	}
+	assert.Contains(suite.T(), document.Paths["/cars/_batch"], "post")
+	assert.Equal(suite.T(), []interface{}{"ID", "Model"}, document.Components.Schemas["cars"]["required"], "the configured schema is used")
+	assert.Equal(suite.T(), "object", document.Components.Schemas["query"]["type"])
+}
+
+func (suite *IntegrationTestSuite) TestOpenAPISchemasKeepTheirRefsAndNames() {
+	// when
+	var document OpenAPIDocument
+	suite.HttpGetJson("/_openapi.json", &document)
+
+	// then
+	assert.Equal(suite.T(), map[string]interface{}{"type": "object"}, document.Components.Schemas["tenants_tenant_orders"])
+	orders := document.Components.Schemas["tenants_tenant_orders_2"]
+	assert.Equal(suite.T(), []interface{}{"ID"}, orders["required"], "/tenants_tenant/orders gets a name of its own")
+	assert.NotContains(suite.T(), orders, "$id")
+	lines := orders["properties"].(map[string]interface{})["Lines"].(map[string]interface{})
+	assert.Equal(suite.T(), map[string]interface{}{"$ref": "#/components/schemas/tenants_tenant_orders_2/$defs/line"}, lines["items"])
+}
+
+func (suite *IntegrationTestSuite) TestOpenAPIInferredSchemas() {
+	// given
+	suite.PutToRedisAsJson("query.1", map[string]interface{}{"Model": "Golf"})
+
+	// when
+	var document OpenAPIDocument
+	inferred := func() bool {
+		suite.HttpGetJson("/_openapi.json?infer=true", &document)
+		properties, _ := document.Components.Schemas["query"]["properties"].(map[string]interface{})
+		return properties["Model"] != nil
+	}
+	invalid := suite.HttpGet("/_openapi.json?infer=yes")
+	_ = invalid.Body.Close()
+
+	// then
+	assert.Eventually(suite.T(), inferred, 2*time.Second, 20*time.Millisecond, "samples are kept for graphql.infer_refresh")
+	assert.Equal(suite.T(), map[string]interface{}{"type": "string"}, document.Components.Schemas["query"]["properties"].(map[string]interface{})["Model"])
+	assert.Equal(suite.T(), []interface{}{"ID", "Model"}, document.Components.Schemas["cars"]["required"], "configured schemas are kept")
+	assert.Equal(suite.T(), http.StatusBadRequest, invalid.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestOpenAPIDocumentsOperators() {
+	// when
+	var document OpenAPIDocument
+	suite.HttpGetJson("/_openapi.json", &document)
+
+	// then
+	list := document.Paths["/cars"]["get"].(map[string]interface{})
+	var names []string
+	for _, parameter := range list["parameters"].([]interface{}) {
+		names = append(names, parameter.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(suite.T(), []string{"filters", "_filter", "_q", "_ids"}, names)
+	assert.Contains(suite.T(), list["responses"], "400")
+	assert.Contains(suite.T(), list["responses"], "503")
+}
+
+func (suite *IntegrationTestSuite) TestDocsPage() {
+	// when
+	response := suite.HttpGet("/_docs")
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusOK, response.StatusCode)
+	assert.Contains(suite.T(), string(body), `url: "/_openapi.json"`)
+}
+
+func (suite *IntegrationTestSuite) TestDocsAssetsAreServedLocally() {
+	for _, asset := range []string{"/_docs/assets/swagger-ui-bundle.js", "/_docs/assets/swagger-ui.css"} {
+		// when
+		response := suite.HttpGet(asset)
+		_ = response.Body.Close()
+
+		// then
+		assert.Equal(suite.T(), http.StatusOK, response.StatusCode, asset)
+	}
+}
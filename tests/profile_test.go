@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"redis-go-dispatcher/schema"
+	"redis-go-dispatcher/service"
+)
+
+func (suite *IntegrationTestSuite) putProfiledPeople() []map[string]interface{} {
+	people := []map[string]interface{}{
+		{"ID": "1", "Name": "Alice", "Age": 30.0, "Tags": []interface{}{"a", "b"}, "Address": map[string]interface{}{"City": "Paris"}},
+		{"ID": "2", "Name": "Bob", "Age": 31.5, "Tags": []interface{}{}, "Address": nil},
+	}
+	suite.PutToRedisAsJson("people.1", people[0])
+	suite.PutToRedisAsJson("people.2", people[1])
+	suite.PutToRedis("people.3", "{broken")
+
+	return people
+}
+
+func (suite *IntegrationTestSuite) TestProfileInfersFields() {
+	// given
+	suite.putProfiledPeople()
+
+	// when
+	var profile service.Profile
+	suite.HttpGetJson("/_admin/prefixes/people/profile", &profile)
+
+	// then
+	fields := make(map[string]service.FieldProfile)
+	for _, field := range profile.Fields {
+		fields[field.Path] = field
+	}
+	assert.Equal(suite.T(), 3, profile.Keys)
+	assert.Equal(suite.T(), 3, profile.Sampled)
+	assert.Equal(suite.T(), 1, profile.Invalid)
+	assert.Equal(suite.T(), "people.3", profile.InvalidDocuments[0].Key)
+	assert.Equal(suite.T(), []string{"Address", "Address.City", "Age", "ID", "Name", "Tags", "Tags[any]"}, fieldPaths(profile.Fields))
+	assert.Equal(suite.T(), map[string]int{"integer": 1, "number": 1}, fields["Age"].Types)
+	assert.Equal(suite.T(), 0.5, fields["Address"].NullRate)
+	assert.Equal(suite.T(), 1.0, fields["Address.City"].Presence)
+	assert.Equal(suite.T(), 0.5, fields["Tags[any]"].Presence)
+	assert.Equal(suite.T(), 2, fields["Name"].Cardinality)
+	assert.Equal(suite.T(), []interface{}{"a", "b"}, fields["Tags[any]"].Examples)
+}
+
+func (suite *IntegrationTestSuite) TestProfileExportsSchema() {
+	// given
+	people := suite.putProfiledPeople()
+
+	// when
+	var exported map[string]interface{}
+	suite.HttpGetJson("/_admin/prefixes/people/profile?format=schema", &exported)
+
+	// then
+	compiled, err := schema.Compile(exported)
+	assert.NoError(suite.T(), err)
+	for _, person := range people {
+		assert.Empty(suite.T(), compiled.Validate(person))
+	}
+	assert.Equal(suite.T(), []interface{}{"Address", "Age", "ID", "Name", "Tags"}, exported["required"])
+	assert.Equal(suite.T(), []interface{}{"null", "object"}, exported["properties"].(map[string]interface{})["Address"].(map[string]interface{})["type"])
+	assert.NotEmpty(suite.T(), compiled.Validate(map[string]interface{}{"ID": 1}))
+}
+
+func (suite *IntegrationTestSuite) TestProfileSampleSize() {
+	// given
+	suite.putProfiledPeople()
+
+	// when
+	var profile service.Profile
+	suite.HttpGetJson("/_admin/prefixes/people/profile?sample=1", &profile)
+	invalidSample := suite.HttpGet("/_admin/prefixes/people/profile?sample=0")
+	unknownPrefix := suite.HttpGet("/_admin/prefixes/nothing/profile")
+
+	// then
+	assert.Equal(suite.T(), 3, profile.Keys)
+	assert.Equal(suite.T(), 1, profile.Sampled)
+	assert.Equal(suite.T(), http.StatusBadRequest, invalidSample.StatusCode)
+	assert.Equal(suite.T(), http.StatusNotFound, unknownPrefix.StatusCode)
+}
+
+func fieldPaths(fields []service.FieldProfile) []string {
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		paths = append(paths, field.Path)
+	}
+
+	return paths
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TcpProxy forwards connections to Redis and can simulate an outage by dropping
+// every open and new connection while disabled. With a latency it holds back
+// every command sent to Redis, and it counts the GET commands it forwards.
+type TcpProxy struct {
+	listener net.Listener
+	target   string
+	latency  time.Duration
+	gets     atomic.Int64
+	mutex    sync.Mutex
+	disabled bool
+	conns    map[net.Conn]struct{}
+}
+
+func StartTcpProxy(redisURL string) *TcpProxy {
+	return StartSlowTcpProxy(redisURL, 0)
+}
+
+func StartSlowTcpProxy(redisURL string, latency time.Duration) *TcpProxy {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		panic(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	proxy := &TcpProxy{listener: listener, target: u.Host, latency: latency, conns: make(map[net.Conn]struct{})}
+	go proxy.acceptLoop()
+	return proxy
+}
+
+func (p *TcpProxy) URL() string {
+	return "redis://" + p.listener.Addr().String()
+}
+
+// Gets returns the number of GET commands forwarded so far.
+func (p *TcpProxy) Gets() int64 {
+	return p.gets.Load()
+}
+
+func (p *TcpProxy) Disable() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.disabled = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = make(map[net.Conn]struct{})
+}
+
+func (p *TcpProxy) Enable() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.disabled = false
+}
+
+func (p *TcpProxy) Close() {
+	_ = p.listener.Close()
+	p.Disable()
+}
+
+func (p *TcpProxy) acceptLoop() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(client)
+	}
+}
+
+func (p *TcpProxy) forward(client net.Conn) {
+	p.mutex.Lock()
+	disabled := p.disabled
+	p.mutex.Unlock()
+	if disabled {
+		_ = client.Close()
+		return
+	}
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		_ = client.Close()
+		return
+	}
+
+	p.mutex.Lock()
+	p.conns[client] = struct{}{}
+	p.conns[server] = struct{}{}
+	p.mutex.Unlock()
+
+	go func() {
+		p.forwardCommands(server, client)
+		_ = server.Close()
+	}()
+	_, _ = io.Copy(client, server)
+	_ = client.Close()
+}
+
+func (p *TcpProxy) forwardCommands(server net.Conn, client net.Conn) {
+	buffer := make([]byte, 32<<10)
+	for {
+		n, err := client.Read(buffer)
+		if n > 0 {
+			time.Sleep(p.latency)
+			p.gets.Add(int64(bytes.Count(buffer[:n], []byte("$3\r\nGET\r\n"))))
+			if _, err := server.Write(buffer[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
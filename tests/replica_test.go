@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *IntegrationTestSuite) TestReplicaGetByIdSkipsUnhealthyReplica() {
+	// given
+	original := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("replica-cars.1", original)
+
+	// when
+	for i := 0; i < 4; i++ {
+		var result Car
+		suite.HttpGetJson("/replica-cars/1", &result)
+
+		// then
+		assert.Equal(suite.T(), original, result)
+	}
+}
+
+func (suite *IntegrationTestSuite) TestReplicaGetAllFound() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := Car{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("replica-cars.1", car1)
+	suite.PutToRedisAsJson("replica-cars.2", car2)
+
+	// when
+	var result []Car
+	suite.HttpGetJson("/replica-cars", &result)
+
+	// then
+	assert.Equal(suite.T(), 2, len(result))
+	assert.Contains(suite.T(), result, car1)
+	assert.Contains(suite.T(), result, car2)
+}
+
+func (suite *IntegrationTestSuite) TestPrimaryGetByIdFound() {
+	// given
+	original := Car{ID: "1", Model: "Toyota", Year: 2022}
+	suite.PutToRedisAsJson("primary-cars.1", original)
+
+	// when
+	var result Car
+	suite.HttpGetJson("/primary-cars/1", &result)
+
+	// then
+	assert.Equal(suite.T(), original, result)
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"redis-go-dispatcher/schema"
+	"testing"
+)
+
+func (suite *IntegrationTestSuite) TestSchemaDropsViolations() {
+	// given
+	suite.PutToRedisAsJson("checked-cars.1", map[string]interface{}{"ID": "1", "Model": "Beetle", "Year": 1970})
+	suite.PutToRedisAsJson("checked-cars.2", map[string]interface{}{"ID": "2", "Year": 1800})
+
+	// when
+	var all []map[string]interface{}
+	suite.HttpGetJson("/checked-cars", &all)
+	var filtered []map[string]interface{}
+	suite.HttpGetJson("/checked-cars?ID=2", &filtered)
+	response := suite.HttpGet("/checked-cars/2")
+
+	// then
+	assert.Len(suite.T(), all, 1)
+	assert.Equal(suite.T(), "1", all[0]["ID"])
+	assert.Empty(suite.T(), filtered)
+	assert.Equal(suite.T(), http.StatusNotFound, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestSchemaDropsViolationsInBatch() {
+	// given
+	suite.PutToRedisAsJson("checked-cars.1", map[string]interface{}{"ID": "1", "Model": "Beetle"})
+	suite.PutToRedisAsJson("checked-cars.2", map[string]interface{}{"ID": 2, "Model": "Golf"})
+
+	// when
+	var result []map[string]interface{}
+	suite.HttpGetJson("/checked-cars?_ids=1,2", &result)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{{"ID": "1", "Model": "Beetle"}, nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestSchemaDropsViolationsInExpansion() {
+	// given
+	suite.PutToRedisAsJson("checked-cars.1", map[string]interface{}{"ID": "1", "Model": "Beetle", "PredecessorID": "2"})
+	suite.PutToRedisAsJson("checked-cars.2", map[string]interface{}{"ID": "2", "Year": 1800})
+
+	// when
+	var result map[string]interface{}
+	suite.HttpGetJson("/checked-cars/1?_expand=predecessor", &result)
+
+	// then
+	assert.Equal(suite.T(), map[string]interface{}{"ID": "1", "Model": "Beetle", "PredecessorID": "2", "predecessor": nil}, result)
+}
+
+func (suite *IntegrationTestSuite) TestSchemaViolationsCountedOncePerDocument() {
+	// given
+	suite.PutToRedisAsJson("annotated-cars.1", map[string]interface{}{"ID": "1", "Year": 1801})
+	before := suite.MetricValue(`schema_violations_total{prefix="/annotated-cars"}`)
+
+	// when
+	for i := 0; i < 3; i++ {
+		suite.HttpGet("/annotated-cars").Body.Close()
+		suite.HttpGet("/annotated-cars/1").Body.Close()
+	}
+
+	// then
+	assert.Equal(suite.T(), before+1, suite.MetricValue(`schema_violations_total{prefix="/annotated-cars"}`))
+}
+
+func (suite *IntegrationTestSuite) TestSchemaAnnotatesViolations() {
+	// given
+	suite.PutToRedisAsJson("annotated-cars.1", map[string]interface{}{"ID": "1", "Model": "Beetle"})
+	suite.PutToRedisAsJson("annotated-cars.2", map[string]interface{}{"ID": "2", "Year": 1800.5})
+
+	// when
+	var all []map[string]interface{}
+	suite.HttpGetJson("/annotated-cars", &all)
+	var one map[string]interface{}
+	suite.HttpGetJson("/annotated-cars/2", &one)
+
+	// then
+	expectedErrors := []interface{}{
+		map[string]interface{}{"path": "", "message": "missing properties: 'Model'"},
+		map[string]interface{}{"path": "/Year", "message": "expected integer, but got number"},
+	}
+	assert.Len(suite.T(), all, 2)
+	assert.NotContains(suite.T(), all[0], "_schemaErrors")
+	assert.Equal(suite.T(), expectedErrors, all[1]["_schemaErrors"])
+	assert.Equal(suite.T(), expectedErrors, one["_schemaErrors"])
+
+	metricsResponse := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(metricsResponse.Body)
+	assert.Contains(suite.T(), string(body), `schema_violations_total{prefix="/annotated-cars"}`)
+}
+
+func (suite *IntegrationTestSuite) TestSchemaPassesViolations() {
+	// given
+	suite.PutToRedisAsJson("cars.1", map[string]interface{}{"ID": "1"})
+
+	// when
+	var result []map[string]interface{}
+	suite.HttpGetJson("/cars", &result)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{{"ID": "1"}}, result)
+}
+
+func (suite *IntegrationTestSuite) TestSchemaAnnotatesViolationsByDefault() {
+	// given
+	suite.PutToRedisAsJson("graph-cars.1", map[string]interface{}{"ID": "1"})
+
+	// when
+	var result []map[string]interface{}
+	suite.HttpGetJson("/graph-cars", &result)
+
+	// then
+	assert.Len(suite.T(), result, 1)
+	assert.Contains(suite.T(), result[0], "_schemaErrors")
+}
+
+func (suite *IntegrationTestSuite) TestValidatePayload() {
+	// when
+	valid := suite.HttpPostJson("/checked-cars/_validate", map[string]interface{}{"ID": "1", "Model": "Beetle", "Year": 1970})
+	invalid := suite.HttpPostJson("/checked-cars/_validate", map[string]interface{}{"ID": 1, "Year": 1800})
+	var failure struct {
+		Message string
+		Errors  []map[string]string
+	}
+	suite.DecodeJson(invalid, &failure)
+	noSchema := suite.HttpPostJson("/people/_validate", map[string]interface{}{"ID": "1"})
+
+	// then
+	assert.Equal(suite.T(), http.StatusNoContent, valid.StatusCode)
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, invalid.StatusCode)
+	assert.Equal(suite.T(), []map[string]string{
+		{"path": "", "message": "missing properties: 'Model'"},
+		{"path": "/ID", "message": "expected string, but got number"},
+		{"path": "/Year", "message": "must be >= 1886 but found 1800"},
+	}, failure.Errors)
+	assert.NotEqual(suite.T(), http.StatusNoContent, noSchema.StatusCode)
+}
+
+func TestSchemaPropertiesNamedLikeKeywords(t *testing.T) {
+	compiled, err := schema.Compile(map[string]interface{}{
+		"properties": map[string]interface{}{
+			"enum":  map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"},
+			"const": map[string]interface{}{"enum": []interface{}{"a", "b"}},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Nil(t, compiled.Validate(map[string]interface{}{"enum": "abc", "const": "a"}))
+	assert.Equal(t, []schema.Error{
+		{Path: "/const", Message: `value must be one of "a", "b"`},
+		{Path: "/enum", Message: "does not match pattern '^[a-z]+$'"},
+	}, compiled.Validate(map[string]interface{}{"enum": "ABC", "const": "c"}))
+}
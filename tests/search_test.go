@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+)
+
+type SearchedCar struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Year        int    `json:"year"`
+}
+
+func (suite *IntegrationTestSuite) putSearchedCars(prefix string) (SearchedCar, SearchedCar, SearchedCar) {
+	car1 := SearchedCar{ID: "1", Name: "Family wagon", Description: "Roomy, red and reliable. Also a convertible? No.", Year: 2018}
+	car2 := SearchedCar{ID: "2", Name: "Red Convertible", Description: "Open top for sunny days", Year: 2021}
+	car3 := SearchedCar{ID: "3", Name: "Citroën Méhari", Description: "Plastic body, red as a tomato", Year: 1972}
+	suite.PutToRedisAsJson(prefix+"1", car1)
+	suite.PutToRedisAsJson(prefix+"2", car2)
+	suite.PutToRedisAsJson(prefix+"3", car3)
+
+	return car1, car2, car3
+}
+
+func (suite *IntegrationTestSuite) TestSearchRanksByRelevance() {
+	// given
+	car1, car2, _ := suite.putSearchedCars("cars.")
+
+	// when
+	var result []SearchedCar
+	suite.HttpGetJson("/cars?_q="+url.QueryEscape("RED convertible"), &result)
+
+	// then
+	assert.Equal(suite.T(), []SearchedCar{car2, car1}, result, "a match in the weighted name ranks first")
+}
+
+func (suite *IntegrationTestSuite) TestSearchFoldsDiacritics() {
+	// given
+	_, _, car3 := suite.putSearchedCars("cars.")
+
+	// when
+	var result []SearchedCar
+	suite.HttpGetJson("/cars?_q=citroen+mehari", &result)
+
+	// then
+	assert.Equal(suite.T(), []SearchedCar{car3}, result)
+}
+
+func (suite *IntegrationTestSuite) TestSearchOnSnapshotWithFilters() {
+	// given
+	_, car2, car3 := suite.putSearchedCars("cached-cars.")
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []SearchedCar
+	suite.HttpGetJson("/cached-cars?_q=red&year[lt]=2000", &result)
+	var all []SearchedCar
+	suite.HttpGetJson("/cached-cars?_q=red&year[gte]=2000", &all)
+
+	// then
+	assert.Equal(suite.T(), []SearchedCar{car3}, result)
+	assert.Equal(suite.T(), car2, all[0])
+	assert.Len(suite.T(), all, 2)
+}
+
+func (suite *IntegrationTestSuite) TestSearchWithoutSearchFields() {
+	// when
+	response := suite.HttpGet("/query?_q=test")
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+)
+
+func (suite *IntegrationTestSuite) TestRequestTimeoutExceededGetAll() {
+	// given
+	suite.PutToRedisAsJson("timeout-cars.1", Car{ID: "1", Model: "Toyota", Year: 2022})
+
+	// when
+	response := suite.HttpGet("/timeout-cars")
+
+	// then
+	assert.Equal(suite.T(), http.StatusGatewayTimeout, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestRequestTimeoutExceededGetById() {
+	// given
+	suite.PutToRedisAsJson("timeout-cars.1", Car{ID: "1", Model: "Toyota", Year: 2022})
+
+	// when
+	response := suite.HttpGet("/timeout-cars/1")
+
+	// then
+	assert.Equal(suite.T(), http.StatusGatewayTimeout, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestRequestTimeoutNotExceeded() {
+	// given
+	car1 := Car{ID: "1", Model: "Toyota", Year: 2022}
+	car2 := Car{ID: "2", Model: "Honda", Year: 2023}
+	suite.PutToRedisAsJson("bounded-cars.1", car1)
+	suite.PutToRedisAsJson("bounded-cars.2", car2)
+
+	// when
+	var result []Car
+	suite.HttpGetJson("/bounded-cars", &result)
+
+	// then
+	assert.Equal(suite.T(), 2, len(result))
+	assert.Contains(suite.T(), result, car1)
+	assert.Contains(suite.T(), result, car2)
+}
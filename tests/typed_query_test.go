@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+func (suite *IntegrationTestSuite) TestQueryComparesNumbersNumerically() {
+	// given
+	query1 := Query{ID: "1", Name: "TestQuery", Number: 1, FloatNumber: 1.5, Ok: true}
+	suite.PutToRedisAsJson("query.1", query1)
+
+	// when
+	var result []Query
+	suite.HttpGetJson("/query?Number=1.0&FloatNumber=1.50", &result)
+
+	// then
+	assert.Equal(suite.T(), []Query{query1}, result)
+}
+
+func (suite *IntegrationTestSuite) TestQueryMatchesNullAndObjects() {
+	// given
+	suite.PutToRedisAsJson("query.1", map[string]interface{}{"ID": "1", "Owner": nil})
+	suite.PutToRedisAsJson("query.2", map[string]interface{}{"ID": "2", "Owner": map[string]interface{}{"Name": "Alice", "Age": 30}})
+	suite.PutToRedisAsJson("query.3", map[string]interface{}{"ID": "3", "Owner": ""})
+
+	// when
+	var isNull []map[string]interface{}
+	suite.HttpGetJson("/query?Owner=null", &isNull)
+	var isObject []map[string]interface{}
+	suite.HttpGetJson("/query?Owner="+url.QueryEscape(`{"Age": 30.0, "Name": "Alice"}`), &isObject)
+
+	// then
+	assert.Equal(suite.T(), []map[string]interface{}{{"ID": "1", "Owner": nil}}, isNull)
+	assert.Len(suite.T(), isObject, 1)
+	assert.Equal(suite.T(), "2", isObject[0]["ID"])
+}
+
+func (suite *IntegrationTestSuite) TestQueryCaseInsensitive() {
+	// given
+	query1, _, _ := suite.putFilterQueries()
+
+	// when
+	var folded []Query
+	suite.HttpGetJson("/query?Name[ieq]=testquery", &folded)
+	var exact []Query
+	suite.HttpGetJson("/query?Name=testquery", &exact)
+
+	// then
+	assert.Equal(suite.T(), []Query{query1}, folded)
+	assert.Empty(suite.T(), exact)
+}
+
+func (suite *IntegrationTestSuite) TestIndexedQueryIsTyped() {
+	// given
+	car1, _, _ := suite.putIndexedCars()
+	suite.PutToRedisAsJson("indexed-cars.4", map[string]interface{}{"ID": "4", "Model": "2019", "Year": "2019"})
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []CachedCar
+	suite.HttpGetJson("/indexed-cars?Year=2019.0", &result)
+
+	// then
+	assert.Equal(suite.T(), []CachedCar{car1}, result)
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonDefaultSkipsOnlyFilteredReads() {
+	// given
+	query1 := Query{ID: "1", Name: "TestQuery"}
+	suite.PutToRedisAsJson("query.1", query1)
+	suite.PutToRedis("query.2", "[1,2]")
+
+	// when
+	var filtered []Query
+	suite.HttpGetJson("/query?Name=TestQuery", &filtered)
+	var all []interface{}
+	suite.HttpGetJson("/query", &all)
+
+	// then
+	assert.Equal(suite.T(), []Query{query1}, filtered)
+	assert.Len(suite.T(), all, 2)
+	assert.Equal(suite.T(), "TestQuery", all[0].(map[string]interface{})["Name"])
+	assert.Equal(suite.T(), []interface{}{1.0, 2.0}, all[1])
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonSkipped() {
+	// given
+	query1 := Query{ID: "1", Name: "TestQuery"}
+	suite.PutToRedisAsJson("skipping-query.1", query1)
+	suite.PutToRedis("skipping-query.2", "{broken")
+
+	// when
+	var filtered []Query
+	suite.HttpGetJson("/skipping-query?Name=TestQuery", &filtered)
+	var all []Query
+	suite.HttpGetJson("/skipping-query", &all)
+
+	// then
+	assert.Equal(suite.T(), []Query{query1}, filtered)
+	assert.Equal(suite.T(), []Query{query1}, all)
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonCountedOncePerDocument() {
+	// given
+	suite.PutToRedis("skipping-query.1", "{counted once")
+	before := suite.MetricValue(`invalid_documents_total{prefix="/skipping-query"}`)
+
+	// when
+	for i := 0; i < 3; i++ {
+		suite.HttpGet("/skipping-query").Body.Close()
+	}
+
+	// then
+	assert.Equal(suite.T(), before+1, suite.MetricValue(`invalid_documents_total{prefix="/skipping-query"}`))
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonIncluded() {
+	// given
+	suite.PutToRedisAsJson("lenient-query.1", Query{ID: "1", Name: "TestQuery"})
+	suite.PutToRedis("lenient-query.2", "{broken")
+	suite.PutToRedis("lenient-query.3", "[1,2]")
+
+	// when
+	var result []interface{}
+	suite.HttpGetJson("/lenient-query?Name=Other", &result)
+
+	// then
+	assert.Equal(suite.T(), []interface{}{"{broken", []interface{}{1.0, 2.0}}, result)
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonFails() {
+	// given
+	suite.PutToRedisAsJson("strict-query.1", Query{ID: "1", Name: "TestQuery"})
+	suite.PutToRedis("strict-query.2", "{broken")
+
+	// when
+	response := suite.HttpGet("/strict-query")
+	metricsResponse := suite.HttpGet("/_admin/metrics")
+	body, _ := io.ReadAll(metricsResponse.Body)
+	_ = metricsResponse.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadGateway, response.StatusCode)
+	assert.Contains(suite.T(), string(body), `invalid_documents_total{prefix="/strict-query"}`)
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonFailsIndexedFilter() {
+	// given
+	suite.PutToRedisAsJson("strict-indexed-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.PutToRedis("strict-indexed-cars.2", "{broken")
+	suite.WaitForCacheDuration()
+
+	// when
+	response := suite.HttpGet("/strict-indexed-cars?Model=Toyota")
+	_ = response.Body.Close()
+
+	// then
+	assert.Equal(suite.T(), http.StatusBadGateway, response.StatusCode)
+}
+
+func (suite *IntegrationTestSuite) TestInvalidJsonIncludedInSearch() {
+	// given
+	suite.PutToRedisAsJson("lenient-searched-cars.1", CachedCar{ID: "1", Model: "Toyota", Year: 2022})
+	suite.PutToRedis("lenient-searched-cars.2", "{broken")
+	suite.WaitForCacheDuration()
+
+	// when
+	var result []interface{}
+	suite.HttpGetJson("/lenient-searched-cars?_q=toyota", &result)
+
+	// then
+	assert.Len(suite.T(), result, 2)
+	assert.Equal(suite.T(), "{broken", result[1])
+}